@@ -2,42 +2,139 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/domainlist"
 	"github.com/eduardtungatarov/shortener/internal/app/handlers"
+	"github.com/eduardtungatarov/shortener/internal/app/keygen"
 	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/metrics"
 	"github.com/eduardtungatarov/shortener/internal/app/middleware"
 	"github.com/eduardtungatarov/shortener/internal/app/server"
 	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/eduardtungatarov/shortener/internal/app/tracing"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// После первого сигнала возвращаем обработку сигналов по умолчанию:
+	// если остановка зависнет, повторный сигнал завершит процесс.
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	log, err := logger.MakeLogger()
+	cfg, err := config.LoadFromFlag()
+	if err != nil {
+		// Как и при ошибке разбора флагов в пакете flag: сообщение и код 2, без трассировки стека.
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	log, err := logger.MakeLogger(cfg.Log)
 	if err != nil {
 		panic(err)
 	}
+	// Вне запросов logger.FromContext пишет в глобальный логгер zap.
+	zap.ReplaceGlobals(log.Desugar())
+
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("unknown command %q", args[0])
+		}
+		err = runMigrate(ctx, cfg, args[1:])
+		if err != nil {
+			log.Fatalf("failed to migrate: %v", err)
+		}
+		return
+	}
 
-	cfg := config.LoadFromFlag()
+	shutdownTracing, err := tracing.Start(ctx, cfg.Tracing)
+	if err != nil {
+		log.Fatalf("failed to start tracing: %v", err)
+	}
 
 	s, err := storage.MakeStorage(cfg)
 	if err != nil {
 		log.Fatalf("failed to make storage: %v", err)
 	}
-	defer s.Close()
+
+	mx := metrics.MakeMetrics()
+	s, err = mx.InstrumentStorage(s, storage.Backend(cfg))
+	if err != nil {
+		log.Fatalf("failed to instrument storage: %v", err)
+	}
+	s = tracing.InstrumentStorage(s, storage.Backend(cfg))
+
 	err = s.Load(ctx)
 	if err != nil {
 		log.Fatalf("failed to load storage: %v", err)
 	}
 
-	m := middleware.MakeMiddleware(log)
-	h := handlers.MakeHandler(s, cfg.BaseURL, log)
+	keyGen, err := keygen.MakeGenerator(cfg.KeyGenerator)
+	if err != nil {
+		log.Fatalf("failed to make key generator: %v", err)
+	}
 
-	go h.DeleteBatch(ctx)
+	domains, err := domainlist.MakeList(cfg.DomainListPath)
+	if err != nil {
+		log.Fatalf("failed to load domain list: %v", err)
+	}
 
-	err = server.Run(cfg, h, m)
+	m, err := middleware.MakeMiddleware(cfg, s, log)
 	if err != nil {
-		log.Fatalf("failed to run server: %v", err)
+		log.Fatalf("failed to make middleware: %v", err)
+	}
+	if cfg.Clicks.HashKey == "" {
+		log.Warn("CLICK_HASH_KEY не задан: уникальные посетители считаются заново после каждого перезапуска")
+	}
+	h := handlers.MakeHandler(s, cfg.BaseURL, keyGen, domains, cfg.DeleteQueue, cfg.Clicks, log)
+	err = mx.RegisterDeleteQueue(h.DeleteQueueStats)
+	if err != nil {
+		log.Fatalf("failed to register delete queue metrics: %v", err)
+	}
+
+	ln, err := server.Listen(cfg)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	h.Start(ctx, cfg.ExpiredSweepInterval)
+	go domains.Watch(ctx, cfg.DomainListReloadInterval, log)
+
+	exitCode := 0
+	err = server.Run(ctx, ln, cfg, h, m, mx)
+	switch {
+	case errors.Is(err, server.ErrServe):
+		log.Errorf("server failed: %v", err)
+		exitCode = 1
+	case err != nil:
+		log.Errorf("failed to stop server gracefully: %v", err)
+	}
+
+	// Хранилище закрывается последним: до этого в него сохраняются очереди обработчика.
+	err = s.Close()
+	if err != nil {
+		log.Fatalf("failed to close storage: %v", err)
+	}
+
+	// Спаны остановки тоже должны дойти до коллектора, поэтому экспорт останавливается после хранилища.
+	tracingCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err = shutdownTracing(tracingCtx)
+	if err != nil {
+		log.Errorf("failed to flush traces: %v", err)
+	}
+	log.Info("Сервер остановлен")
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
 }
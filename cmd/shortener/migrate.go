@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/migrations"
+)
+
+const migrateUsage = "usage: shortener [flags] migrate up|down|status"
+
+// runMigrate выполняет подкоманду migrate: up применяет все миграции,
+// down откатывает последнюю, status печатает состояние каждой.
+func runMigrate(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	if cfg.Database.DSN == config.DefaultDatabaseDSN {
+		return errors.New("migrate requires database DSN: set -d flag or DATABASE_DSN")
+	}
+
+	db, err := sql.Open("pgx", cfg.Database.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrations.MakeMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s)\n", n)
+	case "down":
+		migration, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		if migration == nil {
+			fmt.Println("no applied migrations")
+			return nil
+		}
+		fmt.Printf("rolled back %04d_%s\n", migration.Version, migration.Name)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if !s.AppliedAt.IsZero() {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/requestid"
+)
+
+// Коды ошибок API. Клиенты ветвятся по коду, а не по тексту сообщения, поэтому коды не меняются.
+const (
+	// CodeInvalidJSON — тело запроса не разбирается как JSON нужной формы.
+	CodeInvalidJSON = "invalid_json"
+	// CodeInvalidEncoding — тело запроса не распаковывается по Content-Encoding.
+	CodeInvalidEncoding = "invalid_encoding"
+	// CodeInvalidRequest — запрос разобран, но поле или параметр не прошли проверку, какое — в details.
+	CodeInvalidRequest = "invalid_request"
+	// CodeInvalidURL — сокращаемый url не является корректным http(s)-адресом.
+	CodeInvalidURL = "invalid_url"
+	// CodeDomainNotAllowed — домен url запрещён списком доменов.
+	CodeDomainNotAllowed = "domain_not_allowed"
+	// CodeInvalidAlias — алиас не подходит по формату или зарезервирован.
+	CodeInvalidAlias = "invalid_alias"
+	// CodeInvalidExpiry — срок жизни ссылки задан неверно.
+	CodeInvalidExpiry = "invalid_expiry"
+	// CodeAliasTaken — алиас уже занят.
+	CodeAliasTaken = "alias_taken"
+	// CodeNotFound — ресурс не найден или принадлежит другому пользователю.
+	CodeNotFound = "not_found"
+	// CodeUnauthorized — нет учётных данных или они недействительны.
+	CodeUnauthorized = "unauthorized"
+	// CodeUnsupportedMediaType — Content-Type запроса не application/json.
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	// CodeBodyTooLarge — тело запроса больше допустимого.
+	CodeBodyTooLarge = "body_too_large"
+	// CodeRateLimited — клиент исчерпал лимит запросов.
+	CodeRateLimited = "rate_limited"
+	// CodeDeleteQueueFull — очередь удаления переполнена, запрос стоит повторить позже.
+	CodeDeleteQueueFull = "delete_queue_full"
+	// CodeShuttingDown — сервис останавливается и новых запросов на удаление не принимает.
+	CodeShuttingDown = "shutting_down"
+	// CodeInternal — внутренняя ошибка; подробности только в логе, найти их можно по request_id.
+	CodeInternal = "internal_error"
+)
+
+// Error — тело ответа с ошибкой для всех маршрутов /api/*.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details — подробности для клиента, например имя неверного поля.
+	Details any `json:"details,omitempty"`
+	// RequestID — id запроса, по которому ошибку можно найти в логах.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Write отвечает ошибкой e со статусом status, проставляя в неё id запроса.
+func Write(res http.ResponseWriter, req *http.Request, status int, e Error) {
+	e.RequestID = requestid.FromContext(req.Context())
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+
+	enc := json.NewEncoder(res)
+	if err := enc.Encode(e); err != nil {
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
+	}
+}
+
+// Internal отвечает 500 с CodeInternal. Причину ошибки вызывающий код пишет в лог сам.
+func Internal(res http.ResponseWriter, req *http.Request) {
+	Write(res, req, http.StatusInternalServerError, Error{Code: CodeInternal, Message: "internal server error"})
+}
@@ -0,0 +1,40 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+const (
+	// keyPrefix отличает ключи сервиса от других секретов, например при поиске утечек в логах.
+	keyPrefix = "sk_"
+	keyBytes  = 32
+	// prefixLen — длина начала ключа, по которому пользователь узнаёт его в списке.
+	prefixLen = 10
+)
+
+// Generate выдаёт новый ключ: префикс и случайные байты в base64url.
+func Generate() (string, error) {
+	b := make([]byte, keyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash возвращает sha256 ключа в hex. Хранилища держат только хеш, сам ключ показывается один раз.
+// Ключи случайные и длинные, поэтому медленный хеш вроде bcrypt не нужен.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Prefix возвращает начало ключа для показа в списке ключей.
+func Prefix(key string) string {
+	if len(key) <= prefixLen {
+		return key
+	}
+	return key[:prefixLen]
+}
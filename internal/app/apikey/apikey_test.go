@@ -0,0 +1,27 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	first, err := Generate()
+	require.NoError(t, err)
+	second, err := Generate()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(first, keyPrefix))
+	assert.NotEqual(t, first, second)
+	assert.Len(t, Prefix(first), prefixLen)
+}
+
+func TestHash(t *testing.T) {
+	assert.Equal(t, Hash("sk_key"), Hash("sk_key"))
+	assert.NotEqual(t, Hash("sk_key"), Hash("sk_other"))
+	assert.Len(t, Hash("sk_key"), 64)
+	assert.NotContains(t, Hash("sk_key"), "sk_key")
+}
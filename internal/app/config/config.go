@@ -2,7 +2,11 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,15 +17,61 @@ const (
 	DefaultBaseURL         = "http://localhost:8080"
 	DefaultFileStoragePath = "/tmp/short-url-db.json"
 	DefaultDatabaseDSN     = ""
+	DefaultKeyGenerator    = "hash"
+	DefaultDomainListPath  = ""
 
- 	UserIDKeyName UserIDKey = "userId"
+	DefaultExpiredSweepInterval     = time.Minute
+	DefaultDomainListReloadInterval = 10 * time.Second
+	DefaultShutdownTimeout          = 10 * time.Second
+	DefaultMaxRequestBodySize       = 1 << 20
+
+	DefaultDeleteWorkers       = 2
+	DefaultDeleteQueueSize     = 1024
+	DefaultDeleteBatchSize     = 500
+	DefaultDeleteFlushInterval = time.Second
+
+	DefaultJWTIssuer      = "shortener"
+	DefaultJWTTTL         = 30 * 24 * time.Hour
+	DefaultJWTRenewBefore = 7 * 24 * time.Hour
+	// DefaultJWTKeyID — kid ключа, заданного без явного идентификатора.
+	DefaultJWTKeyID = "default"
+
+	DefaultCreateRateLimit   = "100/1m"
+	DefaultRedirectRateLimit = "1000/1m"
+	DefaultIssueRateLimit    = "30/1m"
+
+	DefaultLogLevel  = "info"
+	DefaultLogFormat = LogFormatJSON
+
+	DefaultTracingEndpoint    = ""
+	DefaultTracingSampleRatio = 1.0
+
+	UserIDKeyName UserIDKey = "userId"
 )
 
 type Config struct {
 	ServerHostPort  string
 	BaseURL         string
 	FileStoragePath string
+	KeyGenerator    string
+	// ExpiredSweepInterval — период удаления просроченных ссылок.
+	ExpiredSweepInterval time.Duration
+	// DomainListPath — файл со списком запрещённых и разрешённых доменов, пустой — без ограничений.
+	DomainListPath string
+	// DomainListReloadInterval — период проверки файла списка доменов на изменения.
+	DomainListReloadInterval time.Duration
+	// ShutdownTimeout — сколько при остановке ждать завершения запросов и сохранения очередей.
+	ShutdownTimeout time.Duration
+	// MaxRequestBodySize — предельный размер тела запроса в байтах, для сжатого тела — после распаковки.
+	MaxRequestBodySize int64
 	Database
+	Auth
+	Cookie
+	RateLimit
+	DeleteQueue
+	Clicks
+	Tracing
+	Log
 }
 
 type Database struct {
@@ -29,11 +79,127 @@ type Database struct {
 	Timeout time.Duration
 }
 
-func LoadFromFlag() Config {
+type Auth struct {
+	// JWTKeys — ключи подписи токенов. Новые токены подписываются первым,
+	// остальные нужны, чтобы во время ротации принимать токены, подписанные прежними ключами.
+	JWTKeys []JWTKey
+	// JWTIssuer — значение claim iss в выдаваемых и принимаемых токенах.
+	JWTIssuer string
+	// JWTTTL — срок жизни токена.
+	JWTTTL time.Duration
+	// JWTRenewBefore — если до истечения токена осталось меньше, он перевыпускается.
+	JWTRenewBefore time.Duration
+}
+
+type JWTKey struct {
+	ID     string
+	Secret string
+}
+
+// Cookie — атрибуты cookie с токеном пользователя.
+type Cookie struct {
+	HTTPOnly bool
+	Secure   bool
+	SameSite http.SameSite
+	Path     string
+	// MaxAge — срок хранения cookie в браузере, 0 — cookie сессии.
+	MaxAge time.Duration
+}
+
+// DeleteQueue — очередь фонового удаления ссылок. Обработчики копят запросы из очереди
+// и удаляют ссылки одним запросом к хранилищу, набрав BatchSize ссылок или раз в FlushInterval.
+type DeleteQueue struct {
+	Workers       int
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// DefaultDeleteQueue — настройки очереди удаления по умолчанию.
+func DefaultDeleteQueue() DeleteQueue {
+	return DeleteQueue{
+		Workers:       DefaultDeleteWorkers,
+		QueueSize:     DefaultDeleteQueueSize,
+		BatchSize:     DefaultDeleteBatchSize,
+		FlushInterval: DefaultDeleteFlushInterval,
+	}
+}
+
+// Clicks — настройки учёта переходов по ссылкам.
+type Clicks struct {
+	// HashKey — секрет HMAC, которым хешируются ip-адреса посетителей. Пустой — случайный ключ
+	// процесса: уникальные посетители тогда считаются заново после перезапуска и на каждой реплике.
+	HashKey string
+}
+
+// Форматы логов.
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// Log — настройки логгера сервиса.
+type Log struct {
+	// Level — минимальный уровень записей: debug, info, warn или error.
+	Level string
+	// Format — LogFormatJSON для сбора логов в production или LogFormatConsole для чтения глазами.
+	Format string
+}
+
+// Tracing — экспорт трассировок по OTLP/HTTP.
+type Tracing struct {
+	// Endpoint — адрес коллектора, например http://localhost:4318. Пустой — трассировка отключена.
+	Endpoint string
+	// SampleRatio — доля запросов, которые трассируются, если вызывающий сервис не решил за нас.
+	SampleRatio float64
+}
+
+// RateLimit — бюджеты запросов. Бюджеты создания ссылок и переходов есть и у ip-адреса,
+// и у пользователя: запрос расходует обе корзины, поэтому, заводя новых пользователей,
+// клиент не получает новых бюджетов.
+type RateLimit struct {
+	// CreateLimit — бюджет на создание ссылок.
+	CreateLimit RateBudget
+	// RedirectLimit — бюджет на переходы по коротким ссылкам.
+	RedirectLimit RateBudget
+	// IssueLimit — бюджет ip-адреса на выдачу токенов новым пользователям.
+	IssueLimit RateBudget
+}
+
+// RateBudget — корзина маркеров: клиент может сделать до Limit запросов подряд,
+// а корзина снова наполняется целиком за Period. Нулевой Limit отключает ограничение.
+type RateBudget struct {
+	Limit  int
+	Period time.Duration
+}
+
+// DefaultCookie — политика cookie по умолчанию: недоступна из JS, на всём сайте, SameSite=Lax,
+// живёт столько же, сколько токен, и передаётся только по https, если сервис работает по https.
+func DefaultCookie(baseURL string, ttl time.Duration) Cookie {
+	return Cookie{
+		HTTPOnly: true,
+		Secure:   strings.HasPrefix(strings.ToLower(baseURL), "https://"),
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		MaxAge:   ttl,
+	}
+}
+
+// LoadFromFlag читает настройки из флагов и переменных окружения. Некорректный лимит запросов —
+// ошибка: молча подставленный лимит по умолчанию легко не заметить.
+func LoadFromFlag() (Config, error) {
 	flagServer := flag.String("a", DefaultServerHostPort, "отвечает за адрес запуска HTTP-сервера")
 	flagBaseURL := flag.String("b", DefaultBaseURL, "отвечает за базовый адрес результирующего сокращённого URL")
 	flagFileStoragePath := flag.String("f", DefaultFileStoragePath, "путь до файла, куда сохраняются все сокращенные URL")
 	databaseDSN := flag.String("d", DefaultDatabaseDSN, "строка с адресом подключения к БД")
+	keyGenerator := flag.String("k", DefaultKeyGenerator, "стратегия генерации коротких ключей: hash, random или counter")
+	domainListPath := flag.String("l", DefaultDomainListPath, "файл со списком запрещённых и разрешённых доменов")
+	jwtIssuer := flag.String("i", DefaultJWTIssuer, "издатель (iss) JWT")
+	jwtTTL := flag.Duration("t", DefaultJWTTTL, "срок жизни JWT")
+	createRateLimit := flag.String("c", DefaultCreateRateLimit, "лимит создания ссылок на клиента в виде N/период, 0 — без лимита")
+	redirectRateLimit := flag.String("r", DefaultRedirectRateLimit, "лимит переходов по ссылкам на клиента в виде N/период, 0 — без лимита")
+	deleteWorkers := flag.Int("w", DefaultDeleteWorkers, "число обработчиков очереди удаления ссылок")
+	tracingEndpoint := flag.String("o", DefaultTracingEndpoint, "адрес OTLP/HTTP-коллектора трассировок, пусто — без трассировки")
 	flag.Parse()
 
 	aEnv, ok := os.LookupEnv("SERVER_ADDRESS")
@@ -56,13 +222,242 @@ func LoadFromFlag() Config {
 		*databaseDSN = dEnv
 	}
 
+	kEnv, ok := os.LookupEnv("KEY_GENERATOR")
+	if ok {
+		*keyGenerator = kEnv
+	}
+
+	lEnv, ok := os.LookupEnv("DOMAIN_LIST_FILE")
+	if ok {
+		*domainListPath = lEnv
+	}
+
+	iEnv, ok := os.LookupEnv("JWT_ISSUER")
+	if ok {
+		*jwtIssuer = iEnv
+	}
+
+	tEnv, ok := os.LookupEnv("JWT_TTL")
+	if ok {
+		if ttl, err := time.ParseDuration(tEnv); err == nil {
+			*jwtTTL = ttl
+		}
+	}
+
+	cEnv, ok := os.LookupEnv("RATE_LIMIT_CREATE")
+	if ok {
+		*createRateLimit = cEnv
+	}
+
+	rEnv, ok := os.LookupEnv("RATE_LIMIT_REDIRECT")
+	if ok {
+		*redirectRateLimit = rEnv
+	}
+
+	wEnv, ok := os.LookupEnv("DELETE_WORKERS")
+	if ok {
+		if n, err := strconv.Atoi(wEnv); err == nil {
+			*deleteWorkers = n
+		}
+	}
+
+	oEnv, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if ok {
+		*tracingEndpoint = oEnv
+	}
+
+	// Доля трассируемых запросов настраивается только переменной окружения.
+	sampleRatio := DefaultTracingSampleRatio
+	if v, ok := os.LookupEnv("TRACING_SAMPLE_RATIO"); ok {
+		if ratio, err := strconv.ParseFloat(v, 64); err == nil && ratio >= 0 && ratio <= 1 {
+			sampleRatio = ratio
+		}
+	}
+
+	// Размеры очереди удаления настраиваются только переменными окружения.
+	deleteQueue := DefaultDeleteQueue()
+	if *deleteWorkers > 0 {
+		deleteQueue.Workers = *deleteWorkers
+	}
+	if v, ok := os.LookupEnv("DELETE_QUEUE_SIZE"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			deleteQueue.QueueSize = n
+		}
+	}
+	if v, ok := os.LookupEnv("DELETE_BATCH_SIZE"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			deleteQueue.BatchSize = n
+		}
+	}
+	if v, ok := os.LookupEnv("DELETE_FLUSH_INTERVAL"); ok {
+		if interval, err := time.ParseDuration(v); err == nil && interval > 0 {
+			deleteQueue.FlushInterval = interval
+		}
+	}
+
+	// Ключи подписи JWT задаются только переменной окружения JWT_KEYS в виде "kid:secret,kid:secret",
+	// первым — ключ для новых токенов: значения флагов видны в списке процессов.
+	jwtKeys := parseJWTKeys(os.Getenv("JWT_KEYS"))
+
+	createLimit, err := parseRateBudget(*createRateLimit)
+	if err != nil {
+		return Config{}, fmt.Errorf("create rate limit: %w", err)
+	}
+	redirectLimit, err := parseRateBudget(*redirectRateLimit)
+	if err != nil {
+		return Config{}, fmt.Errorf("redirect rate limit: %w", err)
+	}
+	// Бюджет выдачи токенов настраивается только переменной окружения.
+	issueRateLimit := DefaultIssueRateLimit
+	if v, ok := os.LookupEnv("RATE_LIMIT_TOKEN_ISSUE"); ok {
+		issueRateLimit = v
+	}
+	issueLimit, err := parseRateBudget(issueRateLimit)
+	if err != nil {
+		return Config{}, fmt.Errorf("token issue rate limit: %w", err)
+	}
+
+	// Секрет хеширования ip-адресов задаётся только переменной окружения, чтобы не светиться в списке процессов.
+	clicks := Clicks{HashKey: os.Getenv("CLICK_HASH_KEY")}
+
+	maxRequestBodySize := int64(DefaultMaxRequestBodySize)
+	if v, ok := os.LookupEnv("MAX_REQUEST_BODY_SIZE"); ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			maxRequestBodySize = n
+		}
+	}
+
+	shutdownTimeout := DefaultShutdownTimeout
+	if v, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(v); err == nil {
+			shutdownTimeout = timeout
+		}
+	}
+
+	// Логгер настраивается только переменными окружения, проверяет значения logger.MakeLogger.
+	logCfg := Log{Level: DefaultLogLevel, Format: DefaultLogFormat}
+	if v, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		logCfg.Level = v
+	}
+	if v, ok := os.LookupEnv("LOG_FORMAT"); ok {
+		logCfg.Format = v
+	}
+
+	// Политика cookie настраивается только переменными окружения поверх DefaultCookie.
+	cookie := DefaultCookie(*flagBaseURL, *jwtTTL)
+	if v, ok := os.LookupEnv("COOKIE_HTTP_ONLY"); ok {
+		if httpOnly, err := strconv.ParseBool(v); err == nil {
+			cookie.HTTPOnly = httpOnly
+		}
+	}
+	if v, ok := os.LookupEnv("COOKIE_SECURE"); ok {
+		if secure, err := strconv.ParseBool(v); err == nil {
+			cookie.Secure = secure
+		}
+	}
+	if v, ok := os.LookupEnv("COOKIE_SAMESITE"); ok {
+		if sameSite, ok := parseSameSite(v); ok {
+			cookie.SameSite = sameSite
+		}
+	}
+	if v, ok := os.LookupEnv("COOKIE_PATH"); ok {
+		cookie.Path = v
+	}
+	if v, ok := os.LookupEnv("COOKIE_MAX_AGE"); ok {
+		if maxAge, err := time.ParseDuration(v); err == nil {
+			cookie.MaxAge = maxAge
+		}
+	}
+	// Браузеры отбрасывают cookie с SameSite=None без Secure.
+	if cookie.SameSite == http.SameSiteNoneMode {
+		cookie.Secure = true
+	}
+
 	return Config{
-		ServerHostPort:  *flagServer,
-		BaseURL:         *flagBaseURL,
-		FileStoragePath: *flagFileStoragePath,
+		ServerHostPort:           *flagServer,
+		BaseURL:                  *flagBaseURL,
+		FileStoragePath:          *flagFileStoragePath,
+		KeyGenerator:             *keyGenerator,
+		ExpiredSweepInterval:     DefaultExpiredSweepInterval,
+		DomainListPath:           *domainListPath,
+		DomainListReloadInterval: DefaultDomainListReloadInterval,
+		ShutdownTimeout:          shutdownTimeout,
+		MaxRequestBodySize:       maxRequestBodySize,
 		Database: Database{
 			DSN:     *databaseDSN,
 			Timeout: time.Second * 1,
 		},
+		Auth: Auth{
+			JWTKeys:        jwtKeys,
+			JWTIssuer:      *jwtIssuer,
+			JWTTTL:         *jwtTTL,
+			JWTRenewBefore: min(DefaultJWTRenewBefore, *jwtTTL/2),
+		},
+		Cookie: cookie,
+		RateLimit: RateLimit{
+			CreateLimit:   createLimit,
+			RedirectLimit: redirectLimit,
+			IssueLimit:    issueLimit,
+		},
+		DeleteQueue: deleteQueue,
+		Clicks:      clicks,
+		Tracing: Tracing{
+			Endpoint:    *tracingEndpoint,
+			SampleRatio: sampleRatio,
+		},
+		Log: logCfg,
+	}, nil
+}
+
+// parseRateBudget разбирает лимит вида "100/1m" или "0" — без ограничения.
+func parseRateBudget(s string) (RateBudget, error) {
+	s = strings.TrimSpace(s)
+	if s == "0" {
+		return RateBudget{}, nil
+	}
+
+	invalid := fmt.Errorf("invalid value %q, want N/period or 0", s)
+	n, p, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateBudget{}, invalid
+	}
+	limit, err := strconv.Atoi(n)
+	if err != nil || limit < 0 {
+		return RateBudget{}, invalid
+	}
+	period, err := time.ParseDuration(p)
+	if err != nil || period <= 0 {
+		return RateBudget{}, invalid
+	}
+	return RateBudget{Limit: limit, Period: period}, nil
+}
+
+func parseSameSite(s string) (http.SameSite, bool) {
+	switch strings.ToLower(s) {
+	case "lax":
+		return http.SameSiteLaxMode, true
+	case "strict":
+		return http.SameSiteStrictMode, true
+	case "none":
+		return http.SameSiteNoneMode, true
+	}
+	return 0, false
+}
+
+// parseJWTKeys разбирает список "kid:secret,kid:secret". Ключ без kid получает DefaultJWTKeyID.
+func parseJWTKeys(s string) []JWTKey {
+	var keys []JWTKey
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(v, ":")
+		if !ok {
+			id, secret = DefaultJWTKeyID, v
+		}
+		keys = append(keys, JWTKey{ID: id, Secret: secret})
 	}
+	return keys
 }
@@ -3,8 +3,11 @@ package config
 import (
 	"flag"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoadFromFlag(t *testing.T) {
@@ -17,12 +20,15 @@ func TestLoadFromFlag(t *testing.T) {
 		fileStoragePathEnv  string
 		databaseDSNFlag     string
 		databaseDSNEnv      string
+		keyGeneratorFlag    string
+		keyGeneratorEnv     string
 	}
 	type want struct {
 		serverHostPort  string
 		baseURL         string
 		fileStoragePath string
 		databaseDSN     string
+		keyGenerator    string
 	}
 
 	tests := []struct {
@@ -208,6 +214,44 @@ func TestLoadFromFlag(t *testing.T) {
 				fileStoragePath: "/tmp/short-url-db.json",
 			},
 		},
+		{
+			name: "got_keygeneratorflag",
+			got: got{
+				keyGeneratorFlag: "random",
+			},
+			want: want{
+				keyGenerator: "random",
+				//
+				baseURL:         "http://localhost:8080",
+				serverHostPort:  "localhost:8080",
+				fileStoragePath: "/tmp/short-url-db.json",
+			},
+		},
+		{
+			name: "got_keygeneratorflag_and_env",
+			got: got{
+				keyGeneratorFlag: "random",
+				keyGeneratorEnv:  "counter",
+			},
+			want: want{
+				keyGenerator: "counter",
+				//
+				baseURL:         "http://localhost:8080",
+				serverHostPort:  "localhost:8080",
+				fileStoragePath: "/tmp/short-url-db.json",
+			},
+		},
+		{
+			name: "not_got_keygeneratorflag_and_env",
+			got:  got{},
+			want: want{
+				keyGenerator: "hash",
+				//
+				baseURL:         "http://localhost:8080",
+				serverHostPort:  "localhost:8080",
+				fileStoragePath: "/tmp/short-url-db.json",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -227,6 +271,9 @@ func TestLoadFromFlag(t *testing.T) {
 			if tt.got.databaseDSNFlag != "" {
 				os.Args = append(os.Args, "-d", tt.got.databaseDSNFlag)
 			}
+			if tt.got.keyGeneratorFlag != "" {
+				os.Args = append(os.Args, "-k", tt.got.keyGeneratorFlag)
+			}
 
 			// настраиваем env для теста
 			err := os.Unsetenv("SERVER_ADDRESS")
@@ -237,6 +284,8 @@ func TestLoadFromFlag(t *testing.T) {
 			assert.NoError(t, err)
 			err = os.Unsetenv("DATABASE_DSN")
 			assert.NoError(t, err)
+			err = os.Unsetenv("KEY_GENERATOR")
+			assert.NoError(t, err)
 			if tt.got.serverHostPortEnv != "" {
 				err := os.Setenv("SERVER_ADDRESS", tt.got.serverHostPortEnv)
 				assert.NoError(t, err)
@@ -253,14 +302,22 @@ func TestLoadFromFlag(t *testing.T) {
 				err := os.Setenv("DATABASE_DSN", tt.got.databaseDSNEnv)
 				assert.NoError(t, err)
 			}
+			if tt.got.keyGeneratorEnv != "" {
+				err := os.Setenv("KEY_GENERATOR", tt.got.keyGeneratorEnv)
+				assert.NoError(t, err)
+			}
 
 			// проверяем
 			resetCommandLineFlagSet()
-			config := LoadFromFlag()
+			config, err := LoadFromFlag()
+			require.NoError(t, err)
 			assert.Equal(t, tt.want.serverHostPort, config.ServerHostPort, "Ожидается что хост и порт сервера = %v, по факту = %v", tt.want.serverHostPort, config.ServerHostPort)
 			assert.Equal(t, tt.want.baseURL, config.BaseURL, "Ожидается что base URL = %v, по факту = %v", tt.want.baseURL, config.BaseURL)
 			assert.Equal(t, tt.want.fileStoragePath, config.FileStoragePath, "Ожидается что fileStoragePath = %v, по факту = %v", tt.want.fileStoragePath, config.FileStoragePath)
 			assert.Equal(t, tt.want.databaseDSN, config.Database.DSN, "Ожидается что databaseDSN = %v, по факту = %v", tt.want.databaseDSN, config.Database.DSN)
+			if tt.want.keyGenerator != "" {
+				assert.Equal(t, tt.want.keyGenerator, config.KeyGenerator, "Ожидается что keyGenerator = %v, по факту = %v", tt.want.keyGenerator, config.KeyGenerator)
+			}
 
 			// восстанавливаем флаги
 			os.Args = oldOsArgs
@@ -271,3 +328,157 @@ func TestLoadFromFlag(t *testing.T) {
 func resetCommandLineFlagSet() {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 }
+
+func TestParseJWTKeys(t *testing.T) {
+	assert.Nil(t, parseJWTKeys(""))
+	assert.Equal(t, []JWTKey{{ID: DefaultJWTKeyID, Secret: "secret"}}, parseJWTKeys("secret"))
+	assert.Equal(t, []JWTKey{
+		{ID: "2024-10", Secret: "new"},
+		{ID: "2024-09", Secret: "old:with:colons"},
+	}, parseJWTKeys("2024-10:new, 2024-09:old:with:colons,"))
+}
+
+func TestLoadCookiePolicy(t *testing.T) {
+	oldOsArgs := os.Args
+	defer func() { os.Args = oldOsArgs }()
+
+	t.Run("https_defaults", func(t *testing.T) {
+		os.Args = []string{"shortener", "-b", "https://short.example"}
+		resetCommandLineFlagSet()
+
+		cfg, err := LoadFromFlag()
+		require.NoError(t, err)
+		assert.Equal(t, Cookie{
+			HTTPOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/",
+			MaxAge:   DefaultJWTTTL,
+		}, cfg.Cookie)
+	})
+
+	t.Run("envs", func(t *testing.T) {
+		os.Args = []string{"shortener"}
+		t.Setenv("COOKIE_HTTP_ONLY", "false")
+		t.Setenv("COOKIE_SAMESITE", "None")
+		t.Setenv("COOKIE_PATH", "/api")
+		t.Setenv("COOKIE_MAX_AGE", "1h")
+		resetCommandLineFlagSet()
+
+		cfg, err := LoadFromFlag()
+		require.NoError(t, err)
+		assert.Equal(t, Cookie{
+			HTTPOnly: false,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+			Path:     "/api",
+			MaxAge:   time.Hour,
+		}, cfg.Cookie)
+	})
+}
+
+func TestParseRateBudget(t *testing.T) {
+	b, err := parseRateBudget("10/1s")
+	require.NoError(t, err)
+	assert.Equal(t, RateBudget{Limit: 10, Period: time.Second}, b)
+
+	b, err = parseRateBudget("0")
+	require.NoError(t, err)
+	assert.Equal(t, RateBudget{}, b)
+
+	for _, s := range []string{"10/fortnight", "-1/1s", "100", "10/0s", ""} {
+		_, err = parseRateBudget(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLoadRateLimit(t *testing.T) {
+	oldOsArgs := os.Args
+	defer func() { os.Args = oldOsArgs }()
+
+	os.Args = []string{"shortener", "-c", "5/1s", "-r", "0"}
+	t.Setenv("RATE_LIMIT_CREATE", "20/1h")
+	resetCommandLineFlagSet()
+
+	cfg, err := LoadFromFlag()
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{
+		CreateLimit:   RateBudget{Limit: 20, Period: time.Hour},
+		RedirectLimit: RateBudget{},
+		IssueLimit:    RateBudget{Limit: 30, Period: time.Minute},
+	}, cfg.RateLimit)
+
+	for _, env := range []string{"RATE_LIMIT_CREATE", "RATE_LIMIT_REDIRECT", "RATE_LIMIT_TOKEN_ISSUE"} {
+		t.Run("invalid_"+env, func(t *testing.T) {
+			t.Setenv(env, "100 per minute")
+			resetCommandLineFlagSet()
+
+			_, err := LoadFromFlag()
+			assert.ErrorContains(t, err, "100 per minute")
+		})
+	}
+}
+
+func TestLoadDeleteQueue(t *testing.T) {
+	oldOsArgs := os.Args
+	defer func() { os.Args = oldOsArgs }()
+
+	os.Args = []string{"shortener", "-w", "4"}
+	t.Setenv("DELETE_QUEUE_SIZE", "10")
+	t.Setenv("DELETE_BATCH_SIZE", "-5")
+	t.Setenv("DELETE_FLUSH_INTERVAL", "250ms")
+	resetCommandLineFlagSet()
+
+	cfg, err := LoadFromFlag()
+	require.NoError(t, err)
+	assert.Equal(t, DeleteQueue{
+		Workers:       4,
+		QueueSize:     10,
+		BatchSize:     DefaultDeleteBatchSize,
+		FlushInterval: 250 * time.Millisecond,
+	}, cfg.DeleteQueue)
+}
+
+func TestLoadTracing(t *testing.T) {
+	oldOsArgs := os.Args
+	defer func() { os.Args = oldOsArgs }()
+
+	os.Args = []string{"shortener", "-o", "http://collector:4318"}
+	t.Setenv("TRACING_SAMPLE_RATIO", "1.5")
+	resetCommandLineFlagSet()
+
+	cfg, err := LoadFromFlag()
+	require.NoError(t, err)
+	assert.Equal(t, Tracing{
+		Endpoint:    "http://collector:4318",
+		SampleRatio: DefaultTracingSampleRatio,
+	}, cfg.Tracing)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+	resetCommandLineFlagSet()
+
+	cfg, err = LoadFromFlag()
+	require.NoError(t, err)
+	assert.Equal(t, Tracing{SampleRatio: 0.25}, cfg.Tracing)
+}
+
+func TestLoadLog(t *testing.T) {
+	oldOsArgs := os.Args
+	defer func() { os.Args = oldOsArgs }()
+
+	os.Args = []string{"shortener"}
+	resetCommandLineFlagSet()
+
+	cfg, err := LoadFromFlag()
+	require.NoError(t, err)
+	assert.Equal(t, Log{Level: DefaultLogLevel, Format: LogFormatJSON}, cfg.Log)
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_FORMAT", LogFormatConsole)
+	resetCommandLineFlagSet()
+
+	cfg, err = LoadFromFlag()
+	require.NoError(t, err)
+	assert.Equal(t, Log{Level: "debug", Format: LogFormatConsole}, cfg.Log)
+}
@@ -0,0 +1,196 @@
+package domainlist
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+)
+
+var (
+	ErrBlocked    = errors.New("domain is blocked")
+	ErrNotAllowed = errors.New("domain is not in allowlist")
+)
+
+// rules — разобранный файл списка. Правило для домена действует и на все его поддомены.
+type rules struct {
+	deny  map[string]bool
+	allow map[string]bool
+}
+
+// List проверяет домены ссылок по запрещающему и разрешающему спискам из файла.
+// Файл содержит по правилу на строку: "deny <домен>", "allow <домен>" или просто домен,
+// что равносильно deny; пустые строки и всё после "#" пропускаются.
+// Если в файле есть хоть одно правило allow, разрешены только перечисленные в allow домены.
+type List struct {
+	path  string
+	rules atomic.Pointer[rules]
+	// modTime и size последней загрузки меняет только Reload, который вызывается из одной горутины.
+	modTime time.Time
+	size    int64
+}
+
+// MakeList загружает список из файла path. С пустым path список пуст и разрешает все домены.
+func MakeList(path string) (*List, error) {
+	l := &List{path: path}
+	l.rules.Store(&rules{})
+	if path == "" {
+		return l, nil
+	}
+
+	_, err := l.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload перечитывает файл, если он изменился с прошлой загрузки, и сообщает, был ли он перечитан.
+// При ошибке разбора остаются прежние правила.
+func (l *List) Reload() (bool, error) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(l.modTime) && info.Size() == l.size {
+		return false, nil
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	r, err := parse(file)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", l.path, err)
+	}
+
+	l.rules.Store(r)
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	return true, nil
+}
+
+// Watch периодически перечитывает файл списка, пока не отменён ctx.
+func (l *List) Watch(ctx context.Context, interval time.Duration, log *zap.SugaredLogger) {
+	if l.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := l.Reload()
+			if err != nil {
+				log.Warnw("Не удалось перечитать список доменов", "path", l.path, logger.FieldError, err)
+				continue
+			}
+			if reloaded {
+				log.Infow("Список доменов перечитан", "path", l.path)
+			}
+		}
+	}
+}
+
+// Check возвращает ErrBlocked или ErrNotAllowed, если домен url запрещён списком.
+func (l *List) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	host, err := normalizeDomain(u.Hostname())
+	if err != nil {
+		return err
+	}
+
+	r := l.rules.Load()
+	if match(r.deny, host) {
+		return fmt.Errorf("%w: %s", ErrBlocked, host)
+	}
+	if len(r.allow) > 0 && !match(r.allow, host) {
+		return fmt.Errorf("%w: %s", ErrNotAllowed, host)
+	}
+	return nil
+}
+
+// match ищет в set сам host или любой из его родительских доменов.
+func match(set map[string]bool, host string) bool {
+	for {
+		if set[host] {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return false
+		}
+		host = host[i+1:]
+	}
+}
+
+func parse(r io.Reader) (*rules, error) {
+	res := &rules{
+		deny:  make(map[string]bool),
+		allow: make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+
+		var set map[string]bool
+		var domain string
+		switch {
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1:
+			set, domain = res.deny, fields[0]
+		case len(fields) == 2 && fields[0] == "deny":
+			set, domain = res.deny, fields[1]
+		case len(fields) == 2 && fields[0] == "allow":
+			set, domain = res.allow, fields[1]
+		default:
+			return nil, fmt.Errorf("line %d: expected \"[allow|deny] domain\"", n)
+		}
+
+		domain, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		set[domain] = true
+	}
+
+	return res, scanner.Err()
+}
+
+func normalizeDomain(domain string) (string, error) {
+	if net.ParseIP(domain) != nil {
+		return domain, nil
+	}
+
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("domain %q: %w", domain, err)
+	}
+	return ascii, nil
+}
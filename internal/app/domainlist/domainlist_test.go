@@ -0,0 +1,125 @@
+package domainlist
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func writeRules(t *testing.T, path, rules string, modTime time.Time) {
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		url     string
+		wantErr error
+	}{
+		{name: "empty_list", rules: "", url: "https://ya.ru/"},
+		{name: "denied", rules: "evil.example\n", url: "https://evil.example/", wantErr: ErrBlocked},
+		{name: "denied_subdomain", rules: "deny evil.example", url: "https://login.EVIL.example/", wantErr: ErrBlocked},
+		{name: "similar_domain", rules: "deny evil.example", url: "https://notevil.example/"},
+		{name: "idn", rules: "deny пример.рф", url: "https://xn--e1afmkfd.xn--p1ai/", wantErr: ErrBlocked},
+		{name: "comments", rules: "# список\n\nevil.example # фишинг\n", url: "https://evil.example/", wantErr: ErrBlocked},
+		{name: "allowed", rules: "allow ya.ru", url: "https://ya.ru/"},
+		{name: "not_in_allowlist", rules: "allow ya.ru", url: "https://r0.ru/", wantErr: ErrNotAllowed},
+		{name: "deny_wins", rules: "allow ya.ru\ndeny mail.ya.ru", url: "https://mail.ya.ru/", wantErr: ErrBlocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "domains.txt")
+			writeRules(t, path, tt.rules, time.Now())
+
+			l, err := MakeList(path)
+			require.NoError(t, err)
+
+			err = l.Check(tt.url)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMakeListInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	writeRules(t, path, "block evil.example", time.Now())
+
+	_, err := MakeList(path)
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	modTime := time.Now().Add(-time.Hour)
+	writeRules(t, path, "deny evil.example", modTime)
+
+	l, err := MakeList(path)
+	require.NoError(t, err)
+
+	// Файл не менялся — правила не перечитываются.
+	reloaded, err := l.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writeRules(t, path, "deny other.example", modTime.Add(time.Minute))
+	reloaded, err = l.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.NoError(t, l.Check("https://evil.example/"))
+	assert.ErrorIs(t, l.Check("https://other.example/"), ErrBlocked)
+
+	// Сломанный файл не сбрасывает прежние правила.
+	writeRules(t, path, "deny other.example now", modTime.Add(2*time.Minute))
+	_, err = l.Reload()
+	assert.Error(t, err)
+	assert.ErrorIs(t, l.Check("https://other.example/"), ErrBlocked)
+}
+
+func TestWatchLogsReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	writeRules(t, path, "deny evil.example\n", time.Now().Add(-time.Hour))
+
+	l, err := MakeList(path)
+	require.NoError(t, err)
+
+	core, logs := observer.New(zap.InfoLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Watch(ctx, 10*time.Millisecond, zap.New(core).Sugar())
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	writeRules(t, path, "deny other.example\n", time.Now())
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("Список доменов перечитан").FilterField(zap.String("path", path)).Len() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.Remove(path))
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("Не удалось перечитать список доменов").FilterField(zap.String("path", path)).Len() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	entry := logs.FilterMessage("Не удалось перечитать список доменов").All()[0]
+	assert.Equal(t, zap.WarnLevel, entry.Level)
+	assert.Contains(t, entry.ContextMap(), logger.FieldError)
+}
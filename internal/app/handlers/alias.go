@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const maxAliasLen = 64
+
+var (
+	ErrAliasInvalid  = errors.New("alias may contain only latin letters, digits, '-' and '_'")
+	ErrAliasTooLong  = errors.New("alias is too long")
+	ErrAliasReserved = errors.New("alias is reserved")
+)
+
+var aliasRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedAliases совпадают с первым сегментом путей роутера и не могут быть ключами.
+var reservedAliases = map[string]bool{
+	"ping":    true,
+	"api":     true,
+	"metrics": true,
+}
+
+func validateAlias(alias string) error {
+	if len(alias) > maxAliasLen {
+		return ErrAliasTooLong
+	}
+	if !aliasRe.MatchString(alias) {
+		return ErrAliasInvalid
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return ErrAliasReserved
+	}
+	return nil
+}
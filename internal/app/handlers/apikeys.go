@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
+	"github.com/eduardtungatarov/shortener/internal/app/apikey"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxAPIKeyNameLen ограничивает длину названия ключа в символах.
+const maxAPIKeyNameLen = 100
+
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+	// Key — сам ключ, отдаётся только в ответе на создание.
+	Key string `json:"key,omitempty"`
+}
+
+func makeAPIKey(k storage.APIKey) APIKey {
+	return APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		CreatedAt: k.CreatedAt,
+	}
+}
+
+func (h *Handler) HandleCreateAPIKey(res http.ResponseWriter, req *http.Request) {
+	reqStr := struct {
+		Name string `json:"name"`
+	}{}
+
+	if !decodeJSON(res, req, &reqStr) {
+		return
+	}
+
+	if reqStr.Name == "" {
+		writeFieldError(res, req, "name", "name is required")
+		return
+	}
+	if utf8.RuneCountInString(reqStr.Name) > maxAPIKeyNameLen {
+		writeFieldError(res, req, "name", "name is too long")
+		return
+	}
+
+	key, err := apikey.Generate()
+	if err != nil {
+		logger.FromContext(req.Context()).Errorw("Не удалось сгенерировать ключ API", logger.FieldError, err)
+		apierror.Internal(res, req)
+		return
+	}
+
+	k := storage.APIKey{
+		ID:        uuid.NewString(),
+		Name:      reqStr.Name,
+		Hash:      apikey.Hash(key),
+		Prefix:    apikey.Prefix(key),
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	err = h.storage.SaveAPIKey(req.Context(), k)
+	if err != nil {
+		logger.FromContext(req.Context()).Errorw("Не удалось сохранить ключ API", logger.FieldError, err)
+		apierror.Internal(res, req)
+		return
+	}
+
+	resp := makeAPIKey(k)
+	resp.Key = key
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusCreated)
+
+	enc := json.NewEncoder(res)
+	if err := enc.Encode(resp); err != nil {
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
+	}
+}
+
+func (h *Handler) HandleGetAPIKeys(res http.ResponseWriter, req *http.Request) {
+	keys, err := h.storage.GetAPIKeys(req.Context())
+	if err != nil {
+		logger.FromContext(req.Context()).Errorw("Не удалось получить ключи API", logger.FieldError, err)
+		apierror.Internal(res, req)
+		return
+	}
+
+	if len(keys) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resp := make([]APIKey, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, makeAPIKey(k))
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(res)
+	if err := enc.Encode(resp); err != nil {
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
+	}
+}
+
+func (h *Handler) HandleRevokeAPIKey(res http.ResponseWriter, req *http.Request) {
+	id := chi.URLParam(req, "id")
+
+	err := h.storage.RevokeAPIKey(req.Context(), id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeJSONError(res, req, http.StatusNotFound, apierror.CodeNotFound, "api key not found")
+			return
+		}
+
+		logger.FromContext(req.Context()).Errorw("Не удалось отозвать ключ API", logger.FieldError, err)
+		apierror.Internal(res, req)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+)
+
+const blockedPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Ссылка заблокирована</title></head>
+<body>
+<h1>Ссылка заблокирована</h1>
+<p>Переход по этой короткой ссылке отключён: её адрес ведёт на запрещённый домен.</p>
+<p><small>%s</small></p>
+</body>
+</html>
+`
+
+// writeBlockedPage отвечает 451 со страницей-предупреждением вместо редиректа на запрещённый домен.
+func writeBlockedPage(res http.ResponseWriter, req *http.Request, reason error) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusUnavailableForLegalReasons)
+
+	_, err := fmt.Fprintf(res, blockedPage, html.EscapeString(reason.Error()))
+	if err != nil {
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+)
+
+const (
+	clickQueueSize     = 4096
+	clickBatchSize     = 100
+	clickFlushInterval = time.Second
+)
+
+// recordClick ставит клик в очередь, не задерживая редирект.
+// Если очередь переполнена или уже закрыта, клик теряется.
+func (h *Handler) recordClick(req *http.Request, key string) {
+	click := storage.Click{
+		ShortURL:  key,
+		ClickedAt: time.Now(),
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		IPHash:    h.ipHash.hash(req.RemoteAddr),
+	}
+
+	if !h.enqueueClick(click) {
+		logger.FromContext(req.Context()).Warn("Очередь кликов переполнена или закрыта, клик не записан")
+	}
+}
+
+// RecordClicks копит клики из очереди и сохраняет их пачками
+// по размеру пачки или по таймеру.
+func (h *Handler) RecordClicks(ctx context.Context) {
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.Click, 0, clickBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := h.storage.SaveClicks(ctx, batch)
+		if err != nil {
+			h.log.Errorw("Не удалось сохранить клики", logger.FieldError, err, "count", len(batch))
+		}
+		batch = make([]storage.Click, 0, clickBatchSize)
+	}
+
+	for {
+		select {
+		case c, ok := <-h.clickCh:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, c)
+			if len(batch) >= clickBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// ipHasher скрывает адрес клиента, оставляя возможность считать уникальных посетителей.
+// Адресов IPv4 всего 2^32, поэтому голый хеш обращается перебором, а HMAC без ключа — нет.
+type ipHasher struct {
+	key []byte
+}
+
+func makeIPHasher(key string) ipHasher {
+	if key != "" {
+		return ipHasher{key: []byte(key)}
+	}
+
+	b := make([]byte, sha256.Size)
+	_, _ = rand.Read(b)
+	return ipHasher{key: b}
+}
+
+func (h ipHasher) hash(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(host))
+	return hex.EncodeToString(mac.Sum(nil))
+}
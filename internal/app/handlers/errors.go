@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
+	"github.com/eduardtungatarov/shortener/internal/app/domainlist"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+)
+
+// writeJSONError отвечает ошибкой API без подробностей.
+func writeJSONError(res http.ResponseWriter, req *http.Request, status int, code, message string) {
+	apierror.Write(res, req, status, apierror.Error{Code: code, Message: message})
+}
+
+// writeFieldError отвечает 400 с CodeInvalidRequest и именем неверного поля или параметра в details.
+func writeFieldError(res http.ResponseWriter, req *http.Request, field, message string) {
+	apierror.Write(res, req, http.StatusBadRequest, apierror.Error{
+		Code:    apierror.CodeInvalidRequest,
+		Message: message,
+		Details: map[string]string{"field": field},
+	})
+}
+
+// writeURLError отвечает 400 на ошибку checkURL: запрещённый домен и некорректный url различаются кодом.
+func writeURLError(res http.ResponseWriter, req *http.Request, err error) {
+	code := apierror.CodeInvalidURL
+	if errors.Is(err, domainlist.ErrBlocked) || errors.Is(err, domainlist.ErrNotAllowed) {
+		code = apierror.CodeDomainNotAllowed
+	}
+	writeJSONError(res, req, http.StatusBadRequest, code, err.Error())
+}
+
+// decodeJSON разбирает тело запроса в v. Если тело больше лимита, отвечает 413, если не разбирается — 400,
+// и в обоих случаях возвращает false.
+func decodeJSON(res http.ResponseWriter, req *http.Request, v any) bool {
+	defer req.Body.Close()
+
+	err := json.NewDecoder(req.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeJSONError(res, req, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", maxErr.Limit))
+		return false
+	}
+
+	logger.FromContext(req.Context()).Infow("Не удалось разобрать тело запроса", logger.FieldError, err)
+	apierror.Write(res, req, http.StatusBadRequest, apierror.Error{
+		Code:    apierror.CodeInvalidJSON,
+		Message: "invalid json body",
+		Details: map[string]string{"reason": err.Error()},
+	})
+	return false
+}
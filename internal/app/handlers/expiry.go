@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxTTLSeconds — наибольший ttl_seconds, который ещё помещается в time.Duration.
+const maxTTLSeconds = math.MaxInt64 / int64(time.Second)
+
+var (
+	ErrExpiryAmbiguous = errors.New("only one of expires_at and ttl_seconds may be set")
+	ErrExpiryInPast    = errors.New("expires_at must be in the future")
+	ErrTTLInvalid      = errors.New("ttl_seconds must be positive")
+	ErrTTLTooLarge     = fmt.Errorf("ttl_seconds must not exceed %d", maxTTLSeconds)
+)
+
+// Expiry — необязательный срок жизни ссылки в теле запроса.
+type Expiry struct {
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds *int64     `json:"ttl_seconds,omitempty"`
+}
+
+// resolve возвращает момент истечения ссылки; нулевое время — ссылка бессрочная.
+func (e Expiry) resolve(now time.Time) (time.Time, error) {
+	switch {
+	case e.ExpiresAt != nil && e.TTLSeconds != nil:
+		return time.Time{}, ErrExpiryAmbiguous
+	case e.ExpiresAt != nil:
+		if !e.ExpiresAt.After(now) {
+			return time.Time{}, ErrExpiryInPast
+		}
+		return *e.ExpiresAt, nil
+	case e.TTLSeconds != nil:
+		if *e.TTLSeconds <= 0 {
+			return time.Time{}, ErrTTLInvalid
+		}
+		if *e.TTLSeconds > maxTTLSeconds {
+			return time.Time{}, ErrTTLTooLarge
+		}
+		return now.Add(time.Duration(*e.TTLSeconds) * time.Second), nil
+	}
+	return time.Time{}, nil
+}
@@ -2,13 +2,54 @@ package handlers
 
 import (
 	"context"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
 )
 
+// DeleteBatch копит запросы на удаление из очереди и удаляет ссылки всех пользователей
+// одним запросом к хранилищу, набрав DeleteQueue.BatchSize ссылок или по таймеру.
+// Таких обработчиков может работать несколько, они делят одну очередь.
 func (h *Handler) DeleteBatch(ctx context.Context) {
-	for r := range h.deleteCh {
-		err := h.storage.DeleteBatch(ctx, r.Urls, r.UserID)
+	ticker := time.NewTicker(h.deletes.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []storage.Deletion
+	var requestIDs []string
+	var size int
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := h.storage.DeleteBatch(ctx, batch)
 		if err != nil {
-			h.log.Info("Не удалось удалить пачку", err)
+			h.log.Errorw("Не удалось удалить пачку", logger.FieldError, err, "count", size, "request_ids", requestIDs)
+		} else {
+			h.deleteStats.flushed.Add(int64(size))
+		}
+		batch = nil
+		requestIDs = nil
+		size = 0
+	}
+
+	for {
+		select {
+		case r, ok := <-h.deleteCh:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, storage.Deletion{UserID: r.UserID, Keys: r.Urls})
+			if r.RequestID != "" {
+				requestIDs = append(requestIDs, r.RequestID)
+			}
+			size += len(r.Urls)
+			if size >= h.deletes.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
 		}
 	}
 }
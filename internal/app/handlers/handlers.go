@@ -2,67 +2,128 @@ package handlers
 
 import (
 	"context"
-	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/domainlist"
+	"github.com/eduardtungatarov/shortener/internal/app/keygen"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/requestid"
 	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/eduardtungatarov/shortener/internal/app/urlnorm"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"io"
-	"log"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type OriginalURL struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	Expiry
+	// expiresAt — срок жизни, вычисленный из Expiry при проверке ссылки в validateBatchItem.
+	expiresAt time.Time
 }
 
 type ShortURL struct {
-	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
-	Key           string `json:"-"`
-	OriginalURL   string `json:"-"`
+	CorrelationID string    `json:"correlation_id"`
+	ShortURL      string    `json:"short_url,omitempty"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	Key           string    `json:"-"`
+	OriginalURL   string    `json:"-"`
+	ExpiresAt     time.Time `json:"-"`
 }
 
+// Статусы ссылок в ответе на пакетное сокращение.
+const (
+	BatchStatusCreated  = "created"
+	BatchStatusExisting = "existing"
+	BatchStatusInvalid  = "invalid"
+)
+
 type DeleteRequest struct {
 	UserID string
-	Urls []string
+	Urls   []string
+	// RequestID — id запроса на удаление: ссылки удаляются позже, и без него сбой не связать с запросом.
+	RequestID string
 }
 
-
 type Storage interface {
-	Set(ctx context.Context, key, value string) error
-	SetBatch(ctx context.Context, keyValues map[string]string) error
-	DeleteBatch(ctx context.Context, keys []string, userID string) error
+	Set(ctx context.Context, key, value string, expiresAt time.Time) error
+	SetAlias(ctx context.Context, alias, value string, expiresAt time.Time) error
+	SetBatch(ctx context.Context, links []storage.Link) ([]error, error)
+	DeleteBatch(ctx context.Context, deletions []storage.Deletion) error
+	DeleteExpired(ctx context.Context) (int64, error)
+	SaveClicks(ctx context.Context, clicks []storage.Click) error
 	Get(ctx context.Context, key string) (string, error)
 	Ping(ctx context.Context) error
 	GetByUserID(ctx context.Context) ([]map[string]string, error)
+	GetClickStats(ctx context.Context, key string, from, to time.Time) (storage.ClickStats, error)
+	SaveAPIKey(ctx context.Context, key storage.APIKey) error
+	GetAPIKeys(ctx context.Context) ([]storage.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
 }
 
+// maxKeyAttempts ограничивает число попыток подобрать свободный ключ.
+const maxKeyAttempts = 10
+
 type Handler struct {
-	storage Storage
-	baseURL string
-	log     *zap.SugaredLogger
+	storage  Storage
+	baseURL  string
+	keyGen   keygen.Generator
+	urlNorm  *urlnorm.Normalizer
+	domains  *domainlist.List
+	log      *zap.SugaredLogger
+	deletes  config.DeleteQueue
 	deleteCh chan DeleteRequest
+	clickCh  chan storage.Click
+	ipHash   ipHasher
+
+	deleteStats deleteStats
+
+	// queueMu защищает отправку в deleteCh и clickCh от их закрытия в Close.
+	queueMu sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
 }
 
-func MakeHandler(storage Storage, baseURL string, log *zap.SugaredLogger) *Handler {
+// MakeHandler создаёт обработчик. Нулевой deletes заменяется на config.DefaultDeleteQueue,
+// пустой ключ в clicks — случайным ключом процесса.
+func MakeHandler(s Storage, baseURL string, keyGen keygen.Generator, domains *domainlist.List,
+	deletes config.DeleteQueue, clicks config.Clicks, log *zap.SugaredLogger) *Handler {
+	if deletes == (config.DeleteQueue{}) {
+		deletes = config.DefaultDeleteQueue()
+	}
+
 	return &Handler{
-		storage: storage,
-		baseURL: baseURL,
-		log:     log,
-		deleteCh: make(chan DeleteRequest, 1024),
+		storage:  s,
+		baseURL:  baseURL,
+		keyGen:   keyGen,
+		urlNorm:  urlnorm.MakeNormalizer(),
+		domains:  domains,
+		log:      log,
+		deletes:  deletes,
+		deleteCh: make(chan DeleteRequest, deletes.QueueSize),
+		clickCh:  make(chan storage.Click, clickQueueSize),
+		ipHash:   makeIPHasher(clicks.HashKey),
 	}
 }
 
 func (h *Handler) HandlePost(res http.ResponseWriter, req *http.Request) {
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Не удалось прочитать тело запроса: %v", err)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			res.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		res.WriteHeader(http.StatusBadRequest)
+		logger.FromContext(req.Context()).Infow("Не удалось прочитать тело запроса", logger.FieldError, err)
 		return
 	}
 	defer req.Body.Close()
@@ -72,12 +133,17 @@ func (h *Handler) HandlePost(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	key := h.getKey(body)
-	err = h.storage.Set(req.Context(), key, string(body))
+	url, err := h.checkURL(string(body))
+	if err != nil {
+		writeURLError(res, req, err)
+		return
+	}
+
+	key, err := h.saveURL(req.Context(), url, time.Time{})
 	isConflict := errors.Is(err, storage.ErrConflict)
 	if err != nil && !isConflict {
 		res.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Не удалось сохранить url: %v", err)
+		logger.FromContext(req.Context()).Errorw("Не удалось сохранить url", logger.FieldError, err)
 		return
 	}
 
@@ -90,7 +156,7 @@ func (h *Handler) HandlePost(res http.ResponseWriter, req *http.Request) {
 	_, err = res.Write([]byte(h.baseURL + "/" + key))
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Ошибка при записи ответа: %v", err)
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
 		return
 	}
 }
@@ -100,43 +166,84 @@ func (h *Handler) HandleGet(res http.ResponseWriter, req *http.Request) {
 
 	url, err := h.storage.Get(req.Context(), shortURL)
 	if err != nil {
-		if errors.Is(err, storage.ErrDeleted) {
+		if errors.Is(err, storage.ErrDeleted) || errors.Is(err, storage.ErrExpired) {
 			res.WriteHeader(http.StatusGone)
 			return
 		}
 
-		log.Printf("Ошибка при получении ссылки: %v", err)
-		res.WriteHeader(http.StatusBadRequest)
+		if errors.Is(err, storage.ErrNotFound) {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.FromContext(req.Context()).Errorw("Не удалось получить ссылку", logger.FieldError, err)
+		res.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
 
+	err = h.domains.Check(url)
+	if errors.Is(err, domainlist.ErrBlocked) || errors.Is(err, domainlist.ErrNotAllowed) {
+		writeBlockedPage(res, req, err)
+		return
+	}
+
+	h.recordClick(req, shortURL)
+
 	res.Header().Add(`Location`, url)
 	res.WriteHeader(http.StatusTemporaryRedirect)
 }
 
 func (h *Handler) HandleShorten(res http.ResponseWriter, req *http.Request) {
 	reqStr := struct {
-		URL string `json:"url"`
+		URL   string `json:"url"`
+		Alias string `json:"alias"`
+		Expiry
 	}{}
 
-	defer req.Body.Close()
-	dec := json.NewDecoder(req.Body)
-	if err := dec.Decode(&reqStr); err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
+	if !decodeJSON(res, req, &reqStr) {
 		return
 	}
 
 	if reqStr.URL == "" {
-		res.WriteHeader(http.StatusBadRequest)
+		writeFieldError(res, req, "url", "url is required")
+		return
+	}
+
+	url, err := h.checkURL(reqStr.URL)
+	if err != nil {
+		writeURLError(res, req, err)
+		return
+	}
+
+	if reqStr.Alias != "" {
+		if err := validateAlias(reqStr.Alias); err != nil {
+			writeJSONError(res, req, http.StatusBadRequest, apierror.CodeInvalidAlias, err.Error())
+			return
+		}
+	}
+
+	expiresAt, err := reqStr.resolve(time.Now())
+	if err != nil {
+		writeJSONError(res, req, http.StatusBadRequest, apierror.CodeInvalidExpiry, err.Error())
 		return
 	}
 
 	// Сохраняем url.
-	key := h.getKey([]byte(reqStr.URL))
-	err := h.storage.Set(req.Context(), key, reqStr.URL)
+	var key string
+	if reqStr.Alias != "" {
+		key = reqStr.Alias
+		err = h.storage.SetAlias(req.Context(), key, url, expiresAt)
+	} else {
+		key, err = h.saveURL(req.Context(), url, expiresAt)
+	}
+	if errors.Is(err, storage.ErrAliasTaken) {
+		writeJSONError(res, req, http.StatusConflict, apierror.CodeAliasTaken, fmt.Sprintf("alias %q is already taken", key))
+		return
+	}
 	isConflict := errors.Is(err, storage.ErrConflict)
 	if err != nil && !isConflict {
-		res.WriteHeader(http.StatusInternalServerError)
+		logger.FromContext(req.Context()).Errorw("Не удалось сохранить url", logger.FieldError, err)
+		apierror.Internal(res, req)
 		return
 	}
 
@@ -164,6 +271,7 @@ func (h *Handler) HandleShorten(res http.ResponseWriter, req *http.Request) {
 func (h *Handler) HandleGetPing(res http.ResponseWriter, req *http.Request) {
 	err := h.storage.Ping(req.Context())
 	if err != nil {
+		logger.FromContext(req.Context()).Warnw("Хранилище недоступно", logger.FieldError, err)
 		res.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
@@ -171,49 +279,105 @@ func (h *Handler) HandleGetPing(res http.ResponseWriter, req *http.Request) {
 	res.WriteHeader(http.StatusOK)
 }
 
+// HandleShortenBatch сокращает пачку url и возвращает статус каждой ссылки.
+// Невалидные ссылки не мешают сохранить остальные, код ответа выбирает batchStatusCode.
 func (h *Handler) HandleShortenBatch(res http.ResponseWriter, req *http.Request) {
 	var batch []OriginalURL
 
-	defer req.Body.Close()
-	dec := json.NewDecoder(req.Body)
-	if err := dec.Decode(&batch); err != nil {
-		log.Printf("decode batch: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
+	if !decodeJSON(res, req, &batch) {
 		return
 	}
 
-	shortURLBatch := h.getShortURLBatch(batch)
-	keyValueBatch := h.getKeyBatch(shortURLBatch)
+	shortURLBatch := make([]ShortURL, len(batch))
+	var valid []OriginalURL
+	var validIdx []int
+	now := time.Now()
+	for i, b := range batch {
+		b, err := h.validateBatchItem(b, now)
+		if err != nil {
+			shortURLBatch[i] = ShortURL{
+				CorrelationID: b.CorrelationID,
+				Status:        BatchStatusInvalid,
+				Error:         err.Error(),
+			}
+			continue
+		}
+		valid = append(valid, b)
+		validIdx = append(validIdx, i)
+	}
 
-	err := h.storage.SetBatch(req.Context(), keyValueBatch)
-	if err != nil {
-		log.Printf("storage SetBatch: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
+	if len(valid) > 0 {
+		saved, err := h.saveBatch(req.Context(), valid)
+		if err != nil {
+			logger.FromContext(req.Context()).Errorw("Не удалось сохранить пачку", logger.FieldError, err)
+			apierror.Internal(res, req)
+			return
+		}
+		for i, v := range saved {
+			shortURLBatch[validIdx[i]] = v
+		}
 	}
 
 	resp, err := json.Marshal(&shortURLBatch)
 	if err != nil {
-		log.Printf("marshal to shortURLBatch: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		logger.FromContext(req.Context()).Errorw("Не удалось сформировать ответ", logger.FieldError, err)
+		apierror.Internal(res, req)
 		return
 	}
 
 	res.Header().Set("Content-Type", "application/json")
-	res.WriteHeader(http.StatusCreated)
+	res.WriteHeader(batchStatusCode(shortURLBatch))
 	_, err = res.Write(resp)
 	if err != nil {
-		log.Printf("response write: %v", err)
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
 
+// validateBatchItem проверяет ссылку пачки и возвращает её с нормализованным url
+// и вычисленным сроком жизни.
+func (h *Handler) validateBatchItem(b OriginalURL, now time.Time) (OriginalURL, error) {
+	if b.OriginalURL == "" {
+		return b, errors.New("original_url is required")
+	}
+
+	url, err := h.checkURL(b.OriginalURL)
+	if err != nil {
+		return b, err
+	}
+	b.OriginalURL = url
+
+	b.expiresAt, err = b.resolve(now)
+	return b, err
+}
+
+// batchStatusCode выбирает код ответа пакетного сокращения: 201, если все ссылки созданы,
+// 409, если все уже были сохранены, 400, если все невалидны, и 207 при смешанных статусах.
+func batchStatusCode(batch []ShortURL) int {
+	statuses := make(map[string]bool)
+	for _, b := range batch {
+		statuses[b.Status] = true
+	}
+	if len(statuses) > 1 {
+		return http.StatusMultiStatus
+	}
+
+	switch {
+	case statuses[BatchStatusExisting]:
+		return http.StatusConflict
+	case statuses[BatchStatusInvalid]:
+		return http.StatusBadRequest
+	default:
+		return http.StatusCreated
+	}
+}
+
 func (h *Handler) HandleGetUserUrls(res http.ResponseWriter, req *http.Request) {
 	urls, err := h.storage.GetByUserID(req.Context())
 	if err != nil {
-		log.Printf("storage GetByUserId: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		logger.FromContext(req.Context()).Errorw("Не удалось получить ссылки пользователя", logger.FieldError, err)
+		apierror.Internal(res, req)
 		return
 	}
 
@@ -237,73 +401,186 @@ func (h *Handler) HandleGetUserUrls(res http.ResponseWriter, req *http.Request)
 
 	body, err := json.Marshal(&respStrSlice)
 	if err != nil {
-		log.Printf("marshal to shortURLBatch: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
+		logger.FromContext(req.Context()).Errorw("Не удалось сформировать ответ", logger.FieldError, err)
+		apierror.Internal(res, req)
 		return
 	}
 
 	_, err = res.Write(body)
 	if err != nil {
-		log.Printf("response write: %v", err)
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
 
 func (h *Handler) HandleDeleteUserUrls(res http.ResponseWriter, req *http.Request) {
-	defer req.Body.Close()
-
 	respStr := []string{}
-	decoder := json.NewDecoder(req.Body)
-	err := decoder.Decode(&respStr)
-	if err != nil {
-		log.Printf("unmarshal body: %v", err)
-		res.WriteHeader(http.StatusBadRequest)
+	if !decodeJSON(res, req, &respStr) {
 		return
 	}
 
 	ctx := req.Context()
 	userID, ok := ctx.Value(config.UserIDKeyName).(string)
-	if !ok  {
-		log.Printf("userID not found: %v", err)
-		res.WriteHeader(http.StatusInternalServerError)
+	if !ok {
+		logger.FromContext(ctx).Error("В контексте нет id пользователя")
+		apierror.Internal(res, req)
+		return
 	}
 
-	h.deleteCh <- DeleteRequest{
-		UserID: userID,
-		Urls: respStr,
+	err := h.enqueueDelete(DeleteRequest{
+		UserID:    userID,
+		Urls:      respStr,
+		RequestID: requestid.FromContext(ctx),
+	})
+	if errors.Is(err, ErrQueueFull) {
+		logger.FromContext(ctx).Warn("Очередь удаления переполнена")
+		res.Header().Set("Retry-After", "1")
+		writeJSONError(res, req, http.StatusServiceUnavailable, apierror.CodeDeleteQueueFull, "delete queue is full, retry later")
+		return
+	}
+	if err != nil {
+		writeJSONError(res, req, http.StatusServiceUnavailable, apierror.CodeShuttingDown, "service is shutting down")
+		return
 	}
 
 	res.WriteHeader(http.StatusAccepted)
 }
 
-func (h *Handler) getKeyBatch(batch []ShortURL) map[string]string {
-	res := map[string]string{}
+func (h *Handler) getLinkBatch(batch []ShortURL, statuses map[string]string) []storage.Link {
+	var res []storage.Link
+	seen := make(map[string]bool)
 	for _, b := range batch {
-		res[b.Key] = b.OriginalURL
+		// Повторы url в пачке получают один ключ и сохраняются один раз.
+		if seen[b.Key] || statuses[b.OriginalURL] != "" {
+			continue
+		}
+		seen[b.Key] = true
+
+		res = append(res, storage.Link{
+			ShortURL:    b.Key,
+			OriginalURL: b.OriginalURL,
+			ExpiresAt:   b.ExpiresAt,
+		})
 	}
 	return res
 }
 
-func (h *Handler) getShortURLBatch(batch []OriginalURL) []ShortURL {
+func (h *Handler) getShortURLBatch(batch []OriginalURL, keys map[string]string, taken map[string]bool) ([]ShortURL, error) {
 	var res []ShortURL
 
 	for _, b := range batch {
-		key := h.getKey([]byte(b.OriginalURL))
+		key, ok := keys[b.OriginalURL]
+		if !ok {
+			var err error
+			key, err = h.freeKey(b.OriginalURL, taken)
+			if err != nil {
+				return nil, err
+			}
+			keys[b.OriginalURL] = key
+			taken[key] = true
+		}
+
 		res = append(res, ShortURL{
 			CorrelationID: b.CorrelationID,
 			ShortURL:      h.baseURL + "/" + key,
 			Key:           key,
 			OriginalURL:   b.OriginalURL,
+			ExpiresAt:     b.expiresAt,
 		})
 	}
 
-	return res
+	return res, nil
+}
+
+// saveURL сохраняет url, подбирая новый ключ, пока хранилище сообщает о коллизии.
+// Если url уже сохранён, возвращает его ключ вместе с storage.ErrConflict.
+func (h *Handler) saveURL(ctx context.Context, url string, expiresAt time.Time) (string, error) {
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		key, err := h.keyGen.Generate(url, attempt)
+		if err != nil {
+			return "", err
+		}
+
+		err = h.storage.Set(ctx, key, url, expiresAt)
+		var keyErr *storage.KeyError
+		switch {
+		case errors.Is(err, storage.ErrKeyCollision):
+			continue
+		case errors.Is(err, storage.ErrConflict) && errors.As(err, &keyErr):
+			return keyErr.Key, err
+		default:
+			return key, err
+		}
+	}
+	return "", keygen.ErrExhausted
+}
+
+// saveBatch сохраняет пачку url и проставляет каждой ссылке статус. Уже сохранённые url
+// получают свой прежний ключ, а для url, чей ключ занят другим url, ключ перевыбирается.
+func (h *Handler) saveBatch(ctx context.Context, batch []OriginalURL) ([]ShortURL, error) {
+	keys := make(map[string]string)
+	taken := make(map[string]bool)
+	statuses := make(map[string]string)
+
+	for attempt := 0; ; attempt++ {
+		shortURLBatch, err := h.getShortURLBatch(batch, keys, taken)
+		if err != nil {
+			return nil, err
+		}
+
+		links := h.getLinkBatch(shortURLBatch, statuses)
+		if len(links) == 0 {
+			for i := range shortURLBatch {
+				shortURLBatch[i].Status = statuses[shortURLBatch[i].OriginalURL]
+			}
+			return shortURLBatch, nil
+		}
+		if attempt == maxKeyAttempts {
+			return nil, keygen.ErrExhausted
+		}
+
+		errs, err := h.storage.SetBatch(ctx, links)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, l := range links {
+			var keyErr *storage.KeyError
+			switch {
+			case errs[i] == nil:
+				statuses[l.OriginalURL] = BatchStatusCreated
+			case errors.Is(errs[i], storage.ErrConflict) && errors.As(errs[i], &keyErr):
+				keys[l.OriginalURL] = keyErr.Key
+				statuses[l.OriginalURL] = BatchStatusExisting
+			case errors.Is(errs[i], storage.ErrKeyCollision):
+				delete(keys, l.OriginalURL)
+			default:
+				return nil, errs[i]
+			}
+		}
+	}
 }
 
-func (h *Handler) getKey(url []byte) string {
-	hash := md5.Sum(url)
-	hashStr := fmt.Sprintf("%x", hash)
-	key := hashStr[:7]
-	return key
+// checkURL нормализует url и проверяет, что его домен не запрещён.
+func (h *Handler) checkURL(raw string) (string, error) {
+	url, err := h.urlNorm.Normalize(raw)
+	if err != nil {
+		return "", err
+	}
+	return url, h.domains.Check(url)
+}
+
+// freeKey подбирает ключ для url, не попадающий в taken.
+func (h *Handler) freeKey(url string, taken map[string]bool) (string, error) {
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		key, err := h.keyGen.Generate(url, attempt)
+		if err != nil {
+			return "", err
+		}
+		if !taken[key] {
+			return key, nil
+		}
+	}
+	return "", keygen.ErrExhausted
 }
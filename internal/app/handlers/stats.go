@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type dayClicks struct {
+	Date   string `json:"date"`
+	Clicks int64  `json:"clicks"`
+}
+
+type referrerClicks struct {
+	Referrer string `json:"referrer"`
+	Clicks   int64  `json:"clicks"`
+}
+
+type userAgentClicks struct {
+	UserAgent string `json:"user_agent"`
+	Clicks    int64  `json:"clicks"`
+}
+
+type URLStats struct {
+	ShortURL       string            `json:"short_url"`
+	TotalClicks    int64             `json:"total_clicks"`
+	UniqueVisitors int64             `json:"unique_visitors"`
+	ClicksPerDay   []dayClicks       `json:"clicks_per_day"`
+	TopReferrers   []referrerClicks  `json:"top_referrers"`
+	TopUserAgents  []userAgentClicks `json:"top_user_agents"`
+}
+
+func (h *Handler) HandleGetURLStats(res http.ResponseWriter, req *http.Request) {
+	key := chi.URLParam(req, "key")
+
+	from, err := parseStatsBound(req.URL.Query().Get("from"), false)
+	if err != nil {
+		writeFieldError(res, req, "from", fmt.Sprintf("invalid from: %v", err))
+		return
+	}
+	to, err := parseStatsBound(req.URL.Query().Get("to"), true)
+	if err != nil {
+		writeFieldError(res, req, "to", fmt.Sprintf("invalid to: %v", err))
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		writeFieldError(res, req, "from", "from must be before to")
+		return
+	}
+
+	stats, err := h.storage.GetClickStats(req.Context(), key, from, to)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotOwned) {
+			writeJSONError(res, req, http.StatusNotFound, apierror.CodeNotFound, "url not found")
+			return
+		}
+
+		logger.FromContext(req.Context()).Errorw("Не удалось получить статистику переходов", logger.FieldError, err)
+		apierror.Internal(res, req)
+		return
+	}
+
+	resp := URLStats{
+		ShortURL:       h.baseURL + "/" + key,
+		TotalClicks:    stats.TotalClicks,
+		UniqueVisitors: stats.UniqueVisitors,
+		ClicksPerDay:   []dayClicks{},
+		TopReferrers:   []referrerClicks{},
+		TopUserAgents:  []userAgentClicks{},
+	}
+	for _, v := range stats.ClicksPerDay {
+		resp.ClicksPerDay = append(resp.ClicksPerDay, dayClicks{Date: v.Value, Clicks: v.Count})
+	}
+	for _, v := range stats.TopReferrers {
+		resp.TopReferrers = append(resp.TopReferrers, referrerClicks{Referrer: v.Value, Clicks: v.Count})
+	}
+	for _, v := range stats.TopUserAgents {
+		resp.TopUserAgents = append(resp.TopUserAgents, userAgentClicks{UserAgent: v.Value, Clicks: v.Count})
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(res)
+	if err := enc.Encode(resp); err != nil {
+		logger.FromContext(req.Context()).Warnw("Не удалось записать ответ", logger.FieldError, err)
+	}
+}
+
+// parseStatsBound разбирает границу периода в формате RFC 3339 или 2006-01-02.
+// Дата без времени в верхней границе включает весь день.
+func parseStatsBound(v string, upper bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC 3339 time or YYYY-MM-DD date")
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+)
+
+// SweepExpired периодически удаляет из хранилища ссылки с истёкшим сроком жизни.
+func (h *Handler) SweepExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := h.storage.DeleteExpired(ctx)
+			if err != nil {
+				h.log.Errorw("Не удалось удалить просроченные ссылки", logger.FieldError, err)
+				continue
+			}
+			if n > 0 {
+				h.log.Infow("Удалены просроченные ссылки", "count", n)
+			}
+		}
+	}
+}
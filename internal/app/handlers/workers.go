@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+)
+
+// ErrClosed — очереди фоновой обработки уже закрыты, сервис останавливается.
+var ErrClosed = errors.New("handler is closed")
+
+// ErrQueueFull — в очереди удаления нет места.
+var ErrQueueFull = errors.New("delete queue is full")
+
+type deleteStats struct {
+	rejected atomic.Int64
+	flushed  atomic.Int64
+}
+
+// DeleteQueueStats — состояние очереди удаления для метрик.
+type DeleteQueueStats struct {
+	// Depth — запросов в очереди сейчас, Capacity — её размер.
+	Depth    int
+	Capacity int
+	// Rejected — запросов, отклонённых из-за переполнения очереди.
+	Rejected int64
+	// Deleted — ссылок, переданных хранилищу на удаление.
+	Deleted int64
+}
+
+func (h *Handler) DeleteQueueStats() DeleteQueueStats {
+	return DeleteQueueStats{
+		Depth:    len(h.deleteCh),
+		Capacity: cap(h.deleteCh),
+		Rejected: h.deleteStats.rejected.Load(),
+		Deleted:  h.deleteStats.flushed.Load(),
+	}
+}
+
+// Start запускает фоновые обработчики: DeleteQueue.Workers обработчиков очереди удаления и обработчик кликов
+// работают до Close, очистка просроченных ссылок останавливается с отменой ctx.
+func (h *Handler) Start(ctx context.Context, sweepInterval time.Duration) {
+	// Очереди дообрабатываются уже после отмены ctx, поэтому их запросы к хранилищу не должны отменяться вместе с ним.
+	queueCtx := context.WithoutCancel(ctx)
+
+	for i := 0; i < h.deletes.Workers; i++ {
+		h.workers.Add(1)
+		go func() {
+			defer h.workers.Done()
+			h.DeleteBatch(queueCtx)
+		}()
+	}
+
+	h.workers.Add(1)
+	go func() {
+		defer h.workers.Done()
+		h.RecordClicks(queueCtx)
+	}()
+
+	go h.SweepExpired(ctx, sweepInterval)
+}
+
+// Close закрывает очереди и ждёт, пока обработчики из Start сохранят всё, что в них успело попасть.
+// Запросы, которые придут после Close, в очереди уже не попадут.
+func (h *Handler) Close(ctx context.Context) error {
+	h.queueMu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.deleteCh)
+		close(h.clickCh)
+	}
+	h.queueMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueueDelete ставит запрос на удаление в очередь. Если места нет, запрос не ждёт,
+// а получает ErrQueueFull: клиенту лучше повторить позже, чем держать соединение.
+func (h *Handler) enqueueDelete(r DeleteRequest) error {
+	h.queueMu.RLock()
+	defer h.queueMu.RUnlock()
+
+	if h.closed {
+		return ErrClosed
+	}
+	select {
+	case h.deleteCh <- r:
+		return nil
+	default:
+		h.deleteStats.rejected.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// enqueueClick ставит клик в очередь, если в ней есть место.
+func (h *Handler) enqueueClick(c storage.Click) bool {
+	h.queueMu.RLock()
+	defer h.queueMu.RUnlock()
+
+	if h.closed {
+		return false
+	}
+	select {
+	case h.clickCh <- c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,18 @@
+package keygen
+
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func encodeBase62(n uint64) string {
+	if n == 0 {
+		return base62Alphabet[:1]
+	}
+
+	var buf [11]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = base62Alphabet[n%62]
+		n /= 62
+	}
+	return string(buf[i:])
+}
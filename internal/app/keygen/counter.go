@@ -0,0 +1,23 @@
+package keygen
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// counterGenerator выдаёт последовательные номера в base62.
+// Счётчик стартует с текущего времени в миллисекундах, чтобы после рестарта
+// не начинать с уже занятых ключей; редкие пересечения закрываются повторной попыткой.
+type counterGenerator struct {
+	counter atomic.Uint64
+}
+
+func MakeCounterGenerator() *counterGenerator {
+	g := &counterGenerator{}
+	g.counter.Store(uint64(time.Now().UnixMilli()))
+	return g
+}
+
+func (g *counterGenerator) Generate(url string, attempt int) (string, error) {
+	return encodeBase62(g.counter.Add(1)), nil
+}
@@ -0,0 +1,27 @@
+package keygen
+
+import (
+	"crypto/md5"
+	"fmt"
+)
+
+// hashGenerator берёт префикс md5 от url и удлиняет его на символ при каждой коллизии.
+type hashGenerator struct {
+	minLen int
+}
+
+func MakeHashGenerator(minLen int) *hashGenerator {
+	return &hashGenerator{
+		minLen: minLen,
+	}
+}
+
+func (g *hashGenerator) Generate(url string, attempt int) (string, error) {
+	hashStr := fmt.Sprintf("%x", md5.Sum([]byte(url)))
+
+	keyLen := g.minLen + attempt
+	if keyLen > len(hashStr) {
+		return "", ErrExhausted
+	}
+	return hashStr[:keyLen], nil
+}
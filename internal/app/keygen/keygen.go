@@ -0,0 +1,34 @@
+package keygen
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	StrategyHash    = "hash"
+	StrategyRandom  = "random"
+	StrategyCounter = "counter"
+
+	defaultKeyLen = 7
+)
+
+var ErrExhausted = errors.New("key candidates exhausted")
+
+// Generator выдаёт ключ-кандидат для url.
+// attempt — номер попытки начиная с 0, растёт при каждой коллизии ключа в хранилище.
+type Generator interface {
+	Generate(url string, attempt int) (string, error)
+}
+
+func MakeGenerator(strategy string) (Generator, error) {
+	switch strategy {
+	case StrategyHash:
+		return MakeHashGenerator(defaultKeyLen), nil
+	case StrategyRandom:
+		return MakeRandomGenerator(defaultKeyLen), nil
+	case StrategyCounter:
+		return MakeCounterGenerator(), nil
+	}
+	return nil, fmt.Errorf("unknown key generator %q", strategy)
+}
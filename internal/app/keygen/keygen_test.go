@@ -0,0 +1,63 @@
+package keygen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashGenerator(t *testing.T) {
+	g := MakeHashGenerator(7)
+
+	key, err := g.Generate("https://practicum.yandex.ru/", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "0dd1981", key)
+
+	// При коллизии ключ удлиняется, сохраняя префикс.
+	key, err = g.Generate("https://practicum.yandex.ru/", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "0dd19817", key)
+
+	_, err = g.Generate("https://practicum.yandex.ru/", 26)
+	assert.ErrorIs(t, err, ErrExhausted)
+}
+
+func TestRandomGenerator(t *testing.T) {
+	g := MakeRandomGenerator(7)
+
+	first, err := g.Generate("https://practicum.yandex.ru/", 0)
+	require.NoError(t, err)
+	second, err := g.Generate("https://practicum.yandex.ru/", 0)
+	require.NoError(t, err)
+
+	assert.Len(t, first, 7)
+	assert.NotEqual(t, first, second)
+	for _, c := range first {
+		assert.Contains(t, base62Alphabet, string(c))
+	}
+}
+
+func TestCounterGenerator(t *testing.T) {
+	g := MakeCounterGenerator()
+	g.counter.Store(61)
+
+	key, err := g.Generate("https://practicum.yandex.ru/", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "10", key)
+
+	key, err = g.Generate("https://practicum.yandex.ru/", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "11", key)
+}
+
+func TestMakeGenerator(t *testing.T) {
+	for _, strategy := range []string{StrategyHash, StrategyRandom, StrategyCounter} {
+		g, err := MakeGenerator(strategy)
+		require.NoError(t, err, strategy)
+		assert.NotNil(t, g, strategy)
+	}
+
+	_, err := MakeGenerator("unknown")
+	assert.Error(t, err)
+}
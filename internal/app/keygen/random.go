@@ -0,0 +1,31 @@
+package keygen
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+// randomGenerator выдаёт случайный base62 ключ, на каждую попытку новый.
+type randomGenerator struct {
+	keyLen int
+}
+
+func MakeRandomGenerator(keyLen int) *randomGenerator {
+	return &randomGenerator{
+		keyLen: keyLen,
+	}
+}
+
+func (g *randomGenerator) Generate(url string, attempt int) (string, error) {
+	max := big.NewInt(int64(len(base62Alphabet)))
+
+	key := make([]byte, g.keyLen)
+	for i := range key {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		key[i] = base62Alphabet[n.Int64()]
+	}
+	return string(key), nil
+}
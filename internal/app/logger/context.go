@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+type ctxKey struct{}
+
+// requestLogger — логгер запроса. Middleware дописывают в него поля по мере того, как узнают
+// о запросе больше, например id пользователя после авторизации. В контексте лежит указатель,
+// чтобы эти поля попали и в записи middleware, стоящих раньше, вроде итоговой записи WithLog.
+type requestLogger struct {
+	mu  sync.Mutex
+	log *zap.SugaredLogger
+}
+
+// WithLogger кладёт в контекст логгер запроса.
+func WithLogger(ctx context.Context, log *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &requestLogger{log: log})
+}
+
+// FromContext возвращает логгер запроса, а вне запроса — глобальный логгер zap.
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if rl, ok := ctx.Value(ctxKey{}).(*requestLogger); ok {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		return rl.log
+	}
+	return zap.S()
+}
+
+// With добавляет пары ключ-значение ко всем следующим записям логгера запроса.
+// Без логгера запроса в контексте ничего не делает.
+func With(ctx context.Context, keysAndValues ...any) {
+	if rl, ok := ctx.Value(ctxKey{}).(*requestLogger); ok {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		rl.log = rl.log.With(keysAndValues...)
+	}
+}
@@ -1,9 +1,41 @@
 package logger
 
-import "go.uber.org/zap"
+import (
+	"fmt"
 
-func MakeLogger() (*zap.SugaredLogger, error) {
-	log, err := zap.NewDevelopment()
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Имена полей, общие для всех записей о запросах.
+const (
+	FieldRequestID = "request_id"
+	FieldUserID    = "user_id"
+	FieldError     = "error"
+)
+
+// MakeLogger собирает логгер по cfg: в формате JSON — с настройками zap для production,
+// в текстовом — с цветными уровнями для чтения в терминале.
+func MakeLogger(cfg config.Log) (*zap.SugaredLogger, error) {
+	level, err := zap.ParseAtomicLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	zcfg := zap.NewProductionConfig()
+	zcfg.Level = level
+	switch cfg.Format {
+	case config.LogFormatJSON:
+	case config.LogFormatConsole:
+		zcfg.Encoding = config.LogFormatConsole
+		zcfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+		zcfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	log, err := zcfg.Build()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMakeLogger(t *testing.T) {
+	log, err := MakeLogger(config.Log{Level: "warn", Format: config.LogFormatJSON})
+	require.NoError(t, err)
+	assert.False(t, log.Desugar().Core().Enabled(zapcore.InfoLevel))
+	assert.True(t, log.Desugar().Core().Enabled(zapcore.WarnLevel))
+
+	_, err = MakeLogger(config.Log{Level: "debug", Format: config.LogFormatConsole})
+	assert.NoError(t, err)
+
+	_, err = MakeLogger(config.Log{Level: "verbose", Format: config.LogFormatJSON})
+	assert.Error(t, err)
+	_, err = MakeLogger(config.Log{Level: "info", Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := WithLogger(context.Background(), zap.New(core).Sugar().With(FieldRequestID, "req-1"))
+
+	// Поля, добавленные в производном контексте, видны и через исходный.
+	With(context.WithValue(ctx, config.UserIDKeyName, "user"), FieldUserID, "user")
+	FromContext(ctx).Info("запись")
+
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, map[string]any{FieldRequestID: "req-1", FieldUserID: "user"}, logs.All()[0].ContextMap())
+
+	// Без логгера запроса With ничего не делает, а FromContext отдаёт глобальный логгер.
+	With(context.Background(), FieldUserID, "user")
+	assert.Same(t, zap.S(), FromContext(context.Background()))
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/handlers"
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+// unmatchedRoute — метка маршрута для запросов, не попавших ни в один маршрут роутера.
+// Подставлять сам путь нельзя: каждый неизвестный путь заводил бы новый временной ряд.
+const unmatchedRoute = "unmatched"
+
+// Metrics держит свой реестр, а не глобальный prometheus.DefaultRegisterer,
+// чтобы в тестах можно было создавать несколько серверов.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+}
+
+func MakeMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Число обработанных HTTP-запросов по шаблону маршрута, методу и статусу ответа.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Время обработки HTTP-запросов по шаблону маршрута, методу и статусу ответа.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "operation_duration_seconds",
+			Help:      "Время операций хранилища по типу хранилища и методу.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "method"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "errors_total",
+			Help:      "Число неожиданных ошибок хранилища по типу хранилища и методу.",
+		}, []string{"backend", "method"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.storageDuration,
+		m.storageErrors,
+	)
+	return m
+}
+
+// Handler отдаёт метрики в формате Prometheus.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WithRequests считает запросы и время их обработки. Шаблон маршрута известен только
+// после того, как роутер выберет обработчик, поэтому метрики пишутся после next.
+func (m *Metrics) WithRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		ww := chimw.NewWrapResponseWriter(res, req.ProtoMajor)
+
+		next.ServeHTTP(ww, req)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := prometheus.Labels{
+			"route":  route,
+			"method": req.Method,
+			"status": strconv.Itoa(status),
+		}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RegisterDeleteQueue публикует состояние очереди удаления, которое stats возвращает на момент сбора метрик.
+func (m *Metrics) RegisterDeleteQueue(stats func() handlers.DeleteQueueStats) error {
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{Namespace: namespace, Subsystem: "delete_queue", Name: name, Help: help}
+	}
+
+	cs := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("depth", "Число запросов на удаление в очереди.")),
+			func() float64 { return float64(stats().Depth) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts(opts("capacity", "Размер очереди удаления.")),
+			func() float64 { return float64(stats().Capacity) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts(opts("rejected_total", "Число запросов на удаление, отклонённых из-за переполнения очереди.")),
+			func() float64 { return float64(stats().Rejected) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts(opts("deleted_total", "Число ссылок, переданных хранилищу на удаление.")),
+			func() float64 { return float64(stats().Deleted) }),
+	}
+	for _, c := range cs {
+		if err := m.registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
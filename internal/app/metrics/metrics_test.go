@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/handlers"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRequests(t *testing.T) {
+	m := MakeMetrics()
+
+	r := chi.NewRouter()
+	r.Use(m.WithRequests)
+	r.Get("/{shortUrl}", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Post("/api/shorten", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/abc", "/def"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/shorten", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a/b/c", nil))
+
+	// Ссылки с разными ключами попадают в один ряд по шаблону маршрута.
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("/{shortUrl}", "GET", "307")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("/api/shorten", "POST", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues(unmatchedRoute, "GET", "404")))
+	assert.Equal(t, 3, testutil.CollectAndCount(m.requestDuration))
+}
+
+type failingStorage struct {
+	storage.Storage
+}
+
+func (s failingStorage) Get(ctx context.Context, key string) (string, error) {
+	if key == "broken" {
+		return "", errors.New("connection reset")
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	m := MakeMetrics()
+	s, err := m.InstrumentStorage(failingStorage{storage.MakeMemoryStorage()}, storage.BackendMemory)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), config.UserIDKeyName, "user")
+	require.NoError(t, s.Set(ctx, "abc", "https://ya.ru/", time.Time{}))
+	assert.ErrorIs(t, s.Set(ctx, "def", "https://ya.ru/", time.Time{}), storage.ErrConflict)
+
+	_, err = s.Get(ctx, "abc")
+	require.NoError(t, err)
+	_, err = s.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.Get(ctx, "broken")
+	assert.Error(t, err)
+
+	require.NoError(t, s.DeleteBatch(ctx, []storage.Deletion{{UserID: "user", Keys: []string{"abc"}}}))
+
+	assert.Equal(t, 3, testutil.CollectAndCount(m.storageDuration), "ряды Set, Get и DeleteBatch")
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.storageErrors.WithLabelValues(storage.BackendMemory, "Set")),
+		"конфликт — ожидаемый ответ, а не сбой")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.storageErrors.WithLabelValues(storage.BackendMemory, "Get")))
+}
+
+func TestHandlerExposesMetrics(t *testing.T) {
+	m := MakeMetrics()
+	stats := handlers.DeleteQueueStats{Depth: 3, Capacity: 10, Rejected: 2, Deleted: 42}
+	require.NoError(t, m.RegisterDeleteQueue(func() handlers.DeleteQueueStats { return stats }))
+
+	s, err := m.InstrumentStorage(storage.MakeMemoryStorage(), storage.BackendMemory)
+	require.NoError(t, err)
+	_, err = s.Get(context.Background(), "abc")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	res := httptest.NewRecorder()
+	m.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	for _, line := range []string{
+		"shortener_delete_queue_depth 3",
+		"shortener_delete_queue_capacity 10",
+		"shortener_delete_queue_rejected_total 2",
+		"shortener_delete_queue_deleted_total 42",
+		`shortener_storage_operation_duration_seconds_count{backend="memory",method="Get"} 1`,
+		"go_goroutines",
+	} {
+		assert.True(t, strings.Contains(string(body), line), "в ответе нет %q", line)
+	}
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolStater — хранилище с пулом соединений к базе.
+type poolStater interface {
+	PoolStat() *pgxpool.Stat
+}
+
+// instrumentedStorage замеряет основные операции хранилища, остальные методы вызываются напрямую.
+type instrumentedStorage struct {
+	storage.Storage
+	metrics *Metrics
+	backend string
+}
+
+// InstrumentStorage оборачивает s метриками времени и ошибок операций, а для хранилища
+// в базе ещё и публикует статистику пула соединений.
+func (m *Metrics) InstrumentStorage(s storage.Storage, backend string) (storage.Storage, error) {
+	if p, ok := s.(poolStater); ok {
+		if err := m.registry.Register(poolCollector{stat: p.PoolStat}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &instrumentedStorage{
+		Storage: s,
+		metrics: m,
+		backend: backend,
+	}, nil
+}
+
+func (s *instrumentedStorage) observe(method string, start time.Time, err error) {
+	s.metrics.storageDuration.WithLabelValues(s.backend, method).Observe(time.Since(start).Seconds())
+	// Ответы о данных вроде ErrNotFound — не сбой, их не считаем ошибками.
+	if err == nil || storage.IsDataError(err) {
+		return
+	}
+	s.metrics.storageErrors.WithLabelValues(s.backend, method).Inc()
+}
+
+func (s *instrumentedStorage) Set(ctx context.Context, key, value string, expiresAt time.Time) error {
+	start := time.Now()
+	err := s.Storage.Set(ctx, key, value, expiresAt)
+	s.observe("Set", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) Get(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	v, err := s.Storage.Get(ctx, key)
+	s.observe("Get", start, err)
+	return v, err
+}
+
+// SetBatch считает ошибкой только сбой всей пачки: ошибки отдельных ссылок — обычный ответ.
+func (s *instrumentedStorage) SetBatch(ctx context.Context, links []storage.Link) ([]error, error) {
+	start := time.Now()
+	errs, err := s.Storage.SetBatch(ctx, links)
+	s.observe("SetBatch", start, err)
+	return errs, err
+}
+
+func (s *instrumentedStorage) DeleteBatch(ctx context.Context, deletions []storage.Deletion) error {
+	start := time.Now()
+	err := s.Storage.DeleteBatch(ctx, deletions)
+	s.observe("DeleteBatch", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetByUserID(ctx context.Context) ([]map[string]string, error) {
+	start := time.Now()
+	urls, err := s.Storage.GetByUserID(ctx)
+	s.observe("GetByUserID", start, err)
+	return urls, err
+}
+
+var (
+	poolAcquiredConns = prometheus.NewDesc(namespace+"_db_pool_acquired_conns",
+		"Число занятых соединений пула.", nil, nil)
+	poolIdleConns = prometheus.NewDesc(namespace+"_db_pool_idle_conns",
+		"Число свободных соединений пула.", nil, nil)
+	poolTotalConns = prometheus.NewDesc(namespace+"_db_pool_total_conns",
+		"Число всех соединений пула.", nil, nil)
+	poolMaxConns = prometheus.NewDesc(namespace+"_db_pool_max_conns",
+		"Максимальный размер пула.", nil, nil)
+	poolAcquireCount = prometheus.NewDesc(namespace+"_db_pool_acquires_total",
+		"Число выданных пулом соединений.", nil, nil)
+	poolEmptyAcquireCount = prometheus.NewDesc(namespace+"_db_pool_empty_acquires_total",
+		"Число запросов соединения, которым пришлось ждать свободного.", nil, nil)
+	poolAcquireDuration = prometheus.NewDesc(namespace+"_db_pool_acquire_duration_seconds_total",
+		"Суммарное время ожидания соединений пула.", nil, nil)
+)
+
+// poolCollector читает статистику пула в момент сбора метрик.
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquireCount
+	ch <- poolEmptyAcquireCount
+	ch <- poolAcquireDuration
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.stat()
+	if st == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(st.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(st.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(st.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(st.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCount, prometheus.CounterValue, float64(st.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquireCount, prometheus.CounterValue, float64(st.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, st.AcquireDuration().Seconds())
+}
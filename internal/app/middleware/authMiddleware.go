@@ -3,94 +3,202 @@ package middleware
 import (
 	"context"
 	"errors"
-	"fmt"
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
+	"github.com/eduardtungatarov/shortener/internal/app/apikey"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
 	"github.com/google/uuid"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// IssuedTokenHeader — заголовок ответа, в котором клиент получает выданный или перевыпущенный токен.
+// Клиент передаёт его в следующих запросах в заголовке Authorization: Bearer.
+const IssuedTokenHeader = "X-Auth-Token"
+
+// APIKeyHeader — заголовок запроса с ключом API. Ключ важнее токена, если клиент прислал оба.
+const APIKeyHeader = "X-API-Key"
+
+var errAuthScheme = errors.New("unsupported authorization scheme")
+
+// authMode — что withAuth делает с запросом без действующих учётных данных.
+type authMode int
+
+const (
+	// authRequired отвечает 401.
+	authRequired authMode = iota
+	// authOptional пропускает запрос без пользователя.
+	authOptional
+	// authIssue заводит нового пользователя и выдаёт ему токен.
+	authIssue
+)
+
+// WithAuth определяет пользователя по токену из заголовка Authorization или cookie,
+// а новому пользователю выдаёт токен.
 func (m *Middleware) WithAuth(next http.Handler) http.Handler {
+	return m.withAuth(next, authIssue)
+}
+
+// WithOptionalAuth определяет пользователя, если он известен, но новому токен не выдаёт:
+// переходы по ссылкам не должны расходовать лимит выдачи токенов.
+func (m *Middleware) WithOptionalAuth(next http.Handler) http.Handler {
+	return m.withAuth(next, authOptional)
+}
+
+// WithRequiredAuth пропускает только запросы с токеном: без учётных данных отвечает 401,
+// а не заводит нового пользователя.
+func (m *Middleware) WithRequiredAuth(next http.Handler) http.Handler {
+	return m.withAuth(next, authRequired)
+}
+
+// withAuth кладёт в контекст id пользователя из ключа API или токена. Новый или перевыпущенный токен,
+// которому до истечения осталось мало, отдаётся в cookie и в заголовке IssuedTokenHeader.
+// Вне authRequired клиент без учётных данных или с непригодной cookie — истёкшей, подписанной
+// убранным ключом, испорченной — не получает 401: иначе браузер со старой cookie не смог бы
+// перейти по короткой ссылке. С authIssue он становится новым пользователем. Недействительный
+// токен из заголовка Authorization отклоняется всегда: его прислал клиент, который должен узнать об ошибке.
+// Выдачу токенов новым пользователям с одного ip-адреса ограничивает config.RateLimit.IssueLimit.
+func (m *Middleware) withAuth(next http.Handler, mode authMode) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		var userID string
-		var token string
-		var setCookie bool
+		var issue bool
+		now := time.Now()
 
-		c, err := req.Cookie(string(config.UserIDKeyName))
+		if key := req.Header.Get(APIKeyHeader); key != "" {
+			m.withAPIKey(next, res, req, key)
+			return
+		}
+
+		token, fromHeader, err := requestToken(req)
 		if err != nil {
-			if !errors.Is(err, http.ErrNoCookie) {
-				res.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			token, err = buildJWTString()
+			logger.FromContext(req.Context()).Infow("Недействительные учётные данные", logger.FieldError, err)
+			unauthorized(res, req)
+			return
+		}
+
+		var claims *Claims
+		if token != "" {
+			claims, err = m.tokens.parse(token)
 			if err != nil {
-				res.WriteHeader(http.StatusInternalServerError)
-				return
+				logger.FromContext(req.Context()).Infow("Недействительный токен", logger.FieldError, err)
+				if fromHeader || mode == authRequired {
+					unauthorized(res, req)
+					return
+				}
 			}
-			setCookie = true
-		} else {
-			token = c.Value
 		}
 
-		userID = getUserID(token)
-		if userID == "" {
-			res.WriteHeader(http.StatusUnauthorized)
+		switch {
+		case claims == nil && mode == authRequired:
+			unauthorized(res, req)
 			return
+		case claims == nil && mode == authOptional:
+			next.ServeHTTP(res, req)
+			return
+		case claims == nil:
+			if m.issueLimit != nil {
+				if d := m.issueLimit.allow(now, ipRateLimitKey(req)); !d.allowed {
+					rateLimited(res, req, d)
+					return
+				}
+			}
+			claims = &Claims{UserID: uuid.NewString()}
+			token, err = m.tokens.issue(claims.UserID, now)
+			if err != nil {
+				logger.FromContext(req.Context()).Errorw("Не удалось выпустить токен", logger.FieldError, err)
+				apierror.Internal(res, req)
+				return
+			}
+			issue = true
+		case m.tokens.needsRenewal(claims, now):
+			token, err = m.tokens.issue(claims.UserID, now)
+			if err != nil {
+				logger.FromContext(req.Context()).Errorw("Не удалось перевыпустить токен", logger.FieldError, err)
+				apierror.Internal(res, req)
+				return
+			}
+			issue = true
 		}
 
 		ctx := req.Context()
-		newCtx := context.WithValue(ctx, config.UserIDKeyName, userID)
+		newCtx := context.WithValue(ctx, config.UserIDKeyName, claims.UserID)
 		req = req.WithContext(newCtx)
+		logger.With(ctx, logger.FieldUserID, claims.UserID)
 
-		if setCookie {
-			cookie := &http.Cookie{
-				Name:  string(config.UserIDKeyName),
-				Value: token,
-			}
-			http.SetCookie(res, cookie)
+		if issue {
+			http.SetCookie(res, m.makeCookie(token))
+			res.Header().Set(IssuedTokenHeader, token)
 		}
 
 		next.ServeHTTP(res, req)
 	})
 }
 
-type Claims struct {
-	jwt.RegisteredClaims
-	UserID string
-}
-
-const secretKey = "supersecretkey"
-
-func buildJWTString() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims {
-		RegisteredClaims: jwt.RegisteredClaims{},
-		UserID: uuid.NewString(),
-	})
+// withAPIKey пропускает запрос от владельца ключа API. Клиентам с ключом токен не выдаётся.
+func (m *Middleware) withAPIKey(next http.Handler, res http.ResponseWriter, req *http.Request, key string) {
+	if m.apiKeys == nil {
+		unauthorized(res, req)
+		return
+	}
 
-	tokenString, err := token.SignedString([]byte(secretKey))
+	userID, err := m.apiKeys.GetUserIDByAPIKey(req.Context(), apikey.Hash(key))
+	if errors.Is(err, storage.ErrNotFound) {
+		logger.FromContext(req.Context()).Infow("Неизвестный ключ API", "prefix", apikey.Prefix(key))
+		unauthorized(res, req)
+		return
+	}
 	if err != nil {
-		return "", err
+		logger.FromContext(req.Context()).Errorw("Ошибка проверки ключа API", logger.FieldError, err)
+		apierror.Internal(res, req)
+		return
 	}
 
-	return tokenString, nil
+	ctx := context.WithValue(req.Context(), config.UserIDKeyName, userID)
+	logger.With(ctx, logger.FieldUserID, userID)
+	next.ServeHTTP(res, req.WithContext(ctx))
 }
 
-func getUserID(tokenString string) string {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(secretKey), nil
-		})
-	if err != nil {
-		return ""
+// makeCookie собирает cookie с токеном по политике из настроек.
+func (m *Middleware) makeCookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     string(config.UserIDKeyName),
+		Value:    token,
+		Path:     m.cookie.Path,
+		MaxAge:   int(m.cookie.MaxAge / time.Second),
+		Secure:   m.cookie.Secure,
+		HttpOnly: m.cookie.HTTPOnly,
+		SameSite: m.cookie.SameSite,
 	}
+}
 
-	if !token.Valid {
-		return ""
+// requestToken достаёт токен из заголовка Authorization: Bearer, а без заголовка — из cookie;
+// fromHeader сообщает, что токен взят из заголовка. Пустой токен без ошибки значит,
+// что клиент не предъявил учётных данных.
+func requestToken(req *http.Request) (token string, fromHeader bool, err error) {
+	if header := req.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		token = strings.TrimSpace(token)
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", true, errAuthScheme
+		}
+		return token, true, nil
 	}
 
-	return claims.UserID
-}
\ No newline at end of file
+	c, err := req.Cookie(string(config.UserIDKeyName))
+	if errors.Is(err, http.ErrNoCookie) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return c.Value, false, nil
+}
+
+func unauthorized(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("WWW-Authenticate", "Bearer")
+	apierror.Write(res, req, http.StatusUnauthorized, apierror.Error{
+		Code:    apierror.CodeUnauthorized,
+		Message: "missing or invalid credentials",
+	})
+}
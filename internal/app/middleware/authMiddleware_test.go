@@ -1,72 +1,445 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"github.com/eduardtungatarov/shortener/internal/app/apikey"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func makeTestMiddleware(t *testing.T, auth config.Auth) *Middleware {
+	if auth.JWTKeys == nil {
+		auth.JWTKeys = []config.JWTKey{{ID: "current", Secret: "current-secret"}}
+	}
+	if auth.JWTRenewBefore == 0 {
+		auth.JWTRenewBefore = time.Hour
+	}
+
+	m, err := MakeMiddleware(config.Config{Auth: auth}, nil, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	return m
+}
+
+func serveWithCookie(m *Middleware, token string, next http.HandlerFunc) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.AddCookie(&http.Cookie{
+			Name:  string(config.UserIDKeyName),
+			Value: token,
+		})
+	}
+	res := httptest.NewRecorder()
+
+	m.WithAuth(next).ServeHTTP(res, req)
+	return res
+}
+
 // TestWithAuth проверяет Middleware WithAuth
 func TestWithAuth(t *testing.T) {
-	m := &Middleware{}
+	m := makeTestMiddleware(t, config.Auth{})
 
 	// Тест для случая, когда куки отсутствуют
 	t.Run("when_cookie_is_missing", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		res := httptest.NewRecorder()
-
-		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := serveWithCookie(m, "", func(w http.ResponseWriter, r *http.Request) {
 			assert.NotNil(t, r.Context().Value(config.UserIDKeyName))
 		})
 
-		m.WithAuth(nextHandler).ServeHTTP(res, req)
-
 		assert.Equal(t, http.StatusOK, res.Code)
-		assert.NotEmpty(t, res.Header().Get("Set-Cookie"))
+		require.Len(t, res.Result().Cookies(), 1)
+
+		claims, err := m.tokens.parse(res.Result().Cookies()[0].Value)
+		require.NoError(t, err)
+		assert.Equal(t, config.DefaultJWTIssuer, claims.Issuer)
+		assert.NotNil(t, claims.IssuedAt)
+		assert.WithinDuration(t, time.Now().Add(config.DefaultJWTTTL), claims.ExpiresAt.Time, time.Minute)
 	})
 
-	// Тест для случая, когда куки есть, но токен недействительный
+	// Тест для случая, когда куки есть, но токен недействительный: клиент получает нового пользователя
 	t.Run("when_cookie_is_invalid", func(t *testing.T) {
-		tokenString := "invalid.token.string"
-		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		req.AddCookie(&http.Cookie{
-			Name:  string(config.UserIDKeyName),
-			Value: tokenString,
+		res := serveWithCookie(m, "invalid.token.string", func(w http.ResponseWriter, r *http.Request) {
+			assert.NotEmpty(t, r.Context().Value(config.UserIDKeyName))
 		})
-		res := httptest.NewRecorder()
 
-		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			assert.Fail(t, "next handler should not be called with invalid token")
+		assert.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, res.Result().Cookies(), 1)
+		_, err := m.tokens.parse(res.Result().Cookies()[0].Value)
+		assert.NoError(t, err)
+	})
+
+	// Тест для случая, когда куки валидный токен
+	t.Run("when_cookie_is_valid", func(t *testing.T) {
+		token, err := m.tokens.issue("user", time.Now())
+		require.NoError(t, err)
+
+		res := serveWithCookie(m, token, func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value(config.UserIDKeyName).(string)
+			assert.Equal(t, "user", userID)
 		})
 
-		m.WithAuth(nextHandler).ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, res.Header().Get("Set-Cookie"))
+	})
+}
+
+func TestWithAuthRejectsTokens(t *testing.T) {
+	m := makeTestMiddleware(t, config.Auth{})
+	secret := []byte("current-secret")
+	now := time.Now()
 
-		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	sign := func(claims Claims, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(secret)
+		require.NoError(t, err)
+		return s
+	}
+	valid := func() Claims {
+		return Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    config.DefaultJWTIssuer,
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(24 * time.Hour)),
+			},
+			UserID: "user",
+		}
+	}
+
+	expired := valid()
+	expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+	noExp := valid()
+	noExp.ExpiresAt = nil
+	noIat := valid()
+	noIat.IssuedAt = nil
+	futureIat := valid()
+	futureIat.IssuedAt = jwt.NewNumericDate(now.Add(time.Hour))
+	wrongIssuer := valid()
+	wrongIssuer.Issuer = "someone-else"
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "expired", token: sign(expired, "current")},
+		{name: "no_exp", token: sign(noExp, "current")},
+		{name: "no_iat", token: sign(noIat, "current")},
+		{name: "iat_in_future", token: sign(futureIat, "current")},
+		{name: "wrong_issuer", token: sign(wrongIssuer, "current")},
+		{name: "no_kid", token: sign(valid(), "")},
+		{name: "unknown_kid", token: sign(valid(), "retired")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// На публичных маршрутах непригодная cookie заменяется новым пользователем.
+			res := serveWithCookie(m, tt.token, func(w http.ResponseWriter, r *http.Request) {
+				assert.NotEqual(t, "user", r.Context().Value(config.UserIDKeyName))
+			})
+			assert.Equal(t, http.StatusOK, res.Code)
+			require.Len(t, res.Result().Cookies(), 1)
+			assert.NotEqual(t, tt.token, res.Result().Cookies()[0].Value)
+
+			// Маршруты пользователя и токен из заголовка её не заменяют.
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			req.AddCookie(&http.Cookie{Name: string(config.UserIDKeyName), Value: tt.token})
+			res = httptest.NewRecorder()
+			m.WithRequiredAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Fail(t, "next handler should not be called with rejected token")
+			})).ServeHTTP(res, req)
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+			req = httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			res = httptest.NewRecorder()
+			m.WithAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Fail(t, "next handler should not be called with rejected token")
+			})).ServeHTTP(res, req)
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
+		})
+	}
+}
+
+func TestWithAuthKeyRotation(t *testing.T) {
+	old := makeTestMiddleware(t, config.Auth{
+		JWTKeys: []config.JWTKey{{ID: "old", Secret: "old-secret"}},
 	})
+	token, err := old.tokens.issue("user", time.Now())
+	require.NoError(t, err)
 
-	// Тест для случая, когда куки валидный токен
-	t.Run("when_cookie_is_valid", func(t *testing.T) {
-		token, err := buildJWTString()
+	// После ротации новый ключ подписывает, а прежний ещё принимается.
+	rotated := makeTestMiddleware(t, config.Auth{
+		JWTKeys: []config.JWTKey{{ID: "new", Secret: "new-secret"}, {ID: "old", Secret: "old-secret"}},
+	})
+	res := serveWithCookie(rotated, token, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "user", r.Context().Value(config.UserIDKeyName))
+	})
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	newToken, err := rotated.tokens.issue("user", time.Now())
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", parsed.Header["kid"])
+
+	// Когда прежний ключ убран из списка, его токены отклоняются, и клиент получает нового пользователя.
+	retired := makeTestMiddleware(t, config.Auth{
+		JWTKeys: []config.JWTKey{{ID: "new", Secret: "new-secret"}},
+	})
+	res = serveWithCookie(retired, token, func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEqual(t, "user", r.Context().Value(config.UserIDKeyName))
+	})
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestMakeMiddlewareRequiresJWTKeys(t *testing.T) {
+	for _, baseURL := range []string{"", "https://short.example", "https://localhost:8080", "http://short.example"} {
+		_, err := MakeMiddleware(config.Config{BaseURL: baseURL}, nil, zap.NewNop().Sugar())
+		assert.ErrorIs(t, err, ErrNoJWTKeys, baseURL)
+	}
+}
+
+func TestMakeMiddlewareLocalDevKey(t *testing.T) {
+	for _, baseURL := range []string{"http://localhost:8080", "http://127.0.0.1:8080", "http://[::1]:8080"} {
+		m, err := MakeMiddleware(config.Config{BaseURL: baseURL}, nil, zap.NewNop().Sugar())
+		require.NoError(t, err, baseURL)
+
+		token, err := m.tokens.issue("user", time.Now())
 		require.NoError(t, err)
+		claims, err := m.tokens.parse(token)
+		require.NoError(t, err)
+		assert.Equal(t, "user", claims.UserID)
+	}
+}
+
+func TestWithAuthRenewal(t *testing.T) {
+	m := makeTestMiddleware(t, config.Auth{
+		JWTTTL:         24 * time.Hour,
+		JWTRenewBefore: time.Hour,
+	})
+
+	// Токен выдан почти сутки назад: до истечения меньше часа.
+	token, err := m.tokens.issue("user", time.Now().Add(-23*time.Hour-30*time.Minute))
+	require.NoError(t, err)
+
+	res := serveWithCookie(m, token, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "user", r.Context().Value(config.UserIDKeyName))
+	})
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	require.Len(t, res.Result().Cookies(), 1)
+	claims, err := m.tokens.parse(res.Result().Cookies()[0].Value)
+	require.NoError(t, err)
+	assert.Equal(t, "user", claims.UserID)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), claims.ExpiresAt.Time, time.Minute)
+}
+
+func TestWithAuthBearer(t *testing.T) {
+	m := makeTestMiddleware(t, config.Auth{})
+	token, err := m.tokens.issue("user", time.Now())
+	require.NoError(t, err)
+
+	t.Run("new_user_gets_token_in_header", func(t *testing.T) {
+		res := serveWithCookie(m, "", func(w http.ResponseWriter, r *http.Request) {})
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		header := res.Header().Get(IssuedTokenHeader)
+		require.NotEmpty(t, header)
+		assert.Equal(t, res.Result().Cookies()[0].Value, header)
+		assert.Empty(t, res.Header().Get("Authorization"))
+	})
 
+	t.Run("valid_bearer", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		req.AddCookie(&http.Cookie{
-			Name:  string(config.UserIDKeyName),
-			Value: token,
-		})
+		req.Header.Set("Authorization", "Bearer "+token)
+		// Заголовок важнее cookie.
+		req.AddCookie(&http.Cookie{Name: string(config.UserIDKeyName), Value: "invalid.token.string"})
 		res := httptest.NewRecorder()
 
-		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userID := r.Context().Value(config.UserIDKeyName).(string)
-			assert.NotEmpty(t, userID)
+		m.WithAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "user", r.Context().Value(config.UserIDKeyName))
+		})).ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, res.Header().Get(IssuedTokenHeader))
+	})
+
+	for _, header := range []string{"Basic dXNlcjpwYXNz", "Bearer", "Bearer invalid.token.string"} {
+		t.Run("rejected_"+header, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", header)
+			res := httptest.NewRecorder()
+
+			m.WithAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Fail(t, "next handler should not be called with invalid credentials")
+			})).ServeHTTP(res, req)
+
+			assert.Equal(t, http.StatusUnauthorized, res.Code)
 		})
+	}
+}
+
+func TestWithRequiredAuth(t *testing.T) {
+	m := makeTestMiddleware(t, config.Auth{})
 
-		m.WithAuth(nextHandler).ServeHTTP(res, req)
+	t.Run("no_credentials", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+		res := httptest.NewRecorder()
+
+		m.WithRequiredAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Fail(t, "next handler should not be called without credentials")
+		})).ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "Bearer", res.Header().Get("WWW-Authenticate"))
+		assert.Empty(t, res.Header().Get("Set-Cookie"))
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		token, err := m.tokens.issue("user", time.Now())
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+		req.AddCookie(&http.Cookie{Name: string(config.UserIDKeyName), Value: token})
+		res := httptest.NewRecorder()
+
+		m.WithRequiredAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "user", r.Context().Value(config.UserIDKeyName))
+		})).ServeHTTP(res, req)
 
 		assert.Equal(t, http.StatusOK, res.Code)
 	})
-}
\ No newline at end of file
+}
+
+func TestWithAuthCookieAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want http.Cookie
+	}{
+		{
+			name: "defaults_for_http",
+			cfg:  config.Config{BaseURL: "http://localhost:8080"},
+			want: http.Cookie{
+				Path:     "/",
+				MaxAge:   int(config.DefaultJWTTTL / time.Second),
+				HttpOnly: true,
+				Secure:   false,
+				SameSite: http.SameSiteLaxMode,
+			},
+		},
+		{
+			name: "defaults_for_https",
+			cfg:  config.Config{BaseURL: "https://short.example"},
+			want: http.Cookie{
+				Path:     "/",
+				MaxAge:   int(config.DefaultJWTTTL / time.Second),
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+			},
+		},
+		{
+			name: "configured",
+			cfg: config.Config{
+				BaseURL: "https://short.example",
+				Cookie: config.Cookie{
+					HTTPOnly: true,
+					Secure:   true,
+					SameSite: http.SameSiteStrictMode,
+					Path:     "/api",
+					MaxAge:   time.Hour,
+				},
+			},
+			want: http.Cookie{
+				Path:     "/api",
+				MaxAge:   3600,
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteStrictMode,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.JWTKeys = []config.JWTKey{{ID: "current", Secret: "current-secret"}}
+			m, err := MakeMiddleware(tt.cfg, nil, zap.NewNop().Sugar())
+			require.NoError(t, err)
+
+			res := serveWithCookie(m, "", func(w http.ResponseWriter, r *http.Request) {})
+			require.Len(t, res.Result().Cookies(), 1)
+			got := res.Result().Cookies()[0]
+
+			assert.Equal(t, string(config.UserIDKeyName), got.Name)
+			assert.Equal(t, tt.want.Path, got.Path)
+			assert.Equal(t, tt.want.MaxAge, got.MaxAge)
+			assert.Equal(t, tt.want.HttpOnly, got.HttpOnly)
+			assert.Equal(t, tt.want.Secure, got.Secure)
+			assert.Equal(t, tt.want.SameSite, got.SameSite)
+		})
+	}
+}
+
+type apiKeyResolverFunc func(ctx context.Context, hash string) (string, error)
+
+func (f apiKeyResolverFunc) GetUserIDByAPIKey(ctx context.Context, hash string) (string, error) {
+	return f(ctx, hash)
+}
+
+func TestWithAuthAPIKey(t *testing.T) {
+	const key = "sk_valid"
+	m := makeTestMiddleware(t, config.Auth{})
+	m.apiKeys = apiKeyResolverFunc(func(ctx context.Context, hash string) (string, error) {
+		switch hash {
+		case apikey.Hash(key):
+			return "key-owner", nil
+		case apikey.Hash("sk_broken"):
+			return "", errors.New("db is down")
+		}
+		return "", storage.ErrNotFound
+	})
+	token, err := m.tokens.issue("token-owner", time.Now())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		key        string
+		statusCode int
+		userID     string
+	}{
+		{name: "valid", key: key, statusCode: http.StatusOK, userID: "key-owner"},
+		{name: "unknown", key: "sk_unknown", statusCode: http.StatusUnauthorized},
+		{name: "storage_error", key: "sk_broken", statusCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(APIKeyHeader, tt.key)
+			// Ключ важнее токена из заголовка Authorization.
+			req.Header.Set("Authorization", "Bearer "+token)
+			res := httptest.NewRecorder()
+
+			var userID string
+			m.WithRequiredAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID = r.Context().Value(config.UserIDKeyName).(string)
+			})).ServeHTTP(res, req)
+
+			assert.Equal(t, tt.statusCode, res.Code)
+			assert.Equal(t, tt.userID, userID)
+			assert.Empty(t, res.Result().Cookies())
+			assert.Empty(t, res.Header().Get(IssuedTokenHeader))
+		})
+	}
+}
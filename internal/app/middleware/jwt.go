@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrUnknownKeyID = errors.New("unknown jwt key id")
+
+// ErrNoJWTKeys — ключи подписи не заданы. Со случайным ключом процесса токены не переживали бы
+// перезапуск и не принимались бы другими репликами, поэтому без ключей запускается только
+// локальный сервис, см. isLocalBaseURL.
+var ErrNoJWTKeys = errors.New("jwt signing keys are not configured, set JWT_KEYS")
+
+type Claims struct {
+	jwt.RegisteredClaims
+	UserID string
+}
+
+// tokenIssuer подписывает токены активным ключом и проверяет их любым известным ключом по kid из заголовка.
+type tokenIssuer struct {
+	activeKeyID string
+	keys        map[string][]byte
+	issuer      string
+	ttl         time.Duration
+	renewBefore time.Duration
+}
+
+// makeTokenIssuer собирает tokenIssuer из настроек. Без ключей возвращает ErrNoJWTKeys.
+func makeTokenIssuer(cfg config.Auth) (*tokenIssuer, error) {
+	keys := cfg.JWTKeys
+	if len(keys) == 0 {
+		return nil, ErrNoJWTKeys
+	}
+
+	ti := &tokenIssuer{
+		activeKeyID: keys[0].ID,
+		keys:        make(map[string][]byte, len(keys)),
+		issuer:      cfg.JWTIssuer,
+		ttl:         cfg.JWTTTL,
+		renewBefore: cfg.JWTRenewBefore,
+	}
+	if ti.issuer == "" {
+		ti.issuer = config.DefaultJWTIssuer
+	}
+	if ti.ttl <= 0 {
+		ti.ttl = config.DefaultJWTTTL
+	}
+
+	for _, k := range keys {
+		if k.Secret == "" {
+			return nil, fmt.Errorf("jwt key %q has empty secret", k.ID)
+		}
+		ti.keys[k.ID] = []byte(k.Secret)
+	}
+	return ti, nil
+}
+
+// devJWTKeys возвращает случайный ключ процесса для локального запуска без JWT_KEYS.
+func devJWTKeys() ([]config.JWTKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return []config.JWTKey{{ID: config.DefaultJWTKeyID, Secret: string(secret)}}, nil
+}
+
+// isLocalBaseURL сообщает, что сервис отдаёт ссылки по http на localhost или loopback-адрес,
+// то есть запущен для разработки или тестов.
+func isLocalBaseURL(baseURL string) bool {
+	u, err := url.Parse(baseURL)
+	if err != nil || !strings.EqualFold(u.Scheme, "http") {
+		return false
+	}
+	host := u.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// issue выдаёт токен пользователю userID, действующий ttl с момента now.
+func (ti *tokenIssuer) issue(userID string, now time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ti.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ti.ttl)),
+		},
+		UserID: userID,
+	})
+	token.Header["kid"] = ti.activeKeyID
+
+	return token.SignedString(ti.keys[ti.activeKeyID])
+}
+
+// parse проверяет подпись, kid, exp, iat и iss токена и возвращает его claims.
+func (ti *tokenIssuer) parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+
+			kid, _ := t.Header["kid"].(string)
+			key, ok := ti.keys[kid]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownKeyID, kid)
+			}
+			return key, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case !claims.VerifyExpiresAt(now, true):
+		return nil, errors.New("token has no expiration")
+	case !claims.VerifyIssuedAt(now, true):
+		return nil, errors.New("token has no issue time")
+	case !claims.VerifyIssuer(ti.issuer, true):
+		return nil, fmt.Errorf("unexpected token issuer: %q", claims.Issuer)
+	case claims.UserID == "":
+		return nil, errors.New("token has no user id")
+	}
+	return claims, nil
+}
+
+// needsRenewal сообщает, что до истечения токена осталось меньше renewBefore.
+func (ti *tokenIssuer) needsRenewal(claims *Claims, now time.Time) bool {
+	return claims.ExpiresAt.Sub(now) < ti.renewBefore
+}
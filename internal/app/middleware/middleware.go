@@ -1,22 +1,94 @@
 package middleware
 
 import (
+	"context"
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/requestid"
 	"go.uber.org/zap"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// APIKeyResolver находит владельца ключа API по его хешу, неизвестный или отозванный ключ — storage.ErrNotFound.
+type APIKeyResolver interface {
+	GetUserIDByAPIKey(ctx context.Context, hash string) (string, error)
+}
+
 type Middleware struct {
-	log *zap.SugaredLogger
+	log     *zap.SugaredLogger
+	tokens  *tokenIssuer
+	cookie  config.Cookie
+	apiKeys APIKeyResolver
+	// maxBodySize — предельный размер тела запроса, см. WithBodyLimit.
+	maxBodySize int64
+
+	createLimit   *rateLimiter
+	redirectLimit *rateLimiter
+	// issueLimit ограничивает выдачу токенов новым пользователям с одного ip-адреса.
+	issueLimit *rateLimiter
 }
 
-func MakeMiddleware(log *zap.SugaredLogger) *Middleware {
-	return &Middleware{
-		log: log,
+func MakeMiddleware(cfg config.Config, apiKeys APIKeyResolver, log *zap.SugaredLogger) (*Middleware, error) {
+	auth := cfg.Auth
+	if len(auth.JWTKeys) == 0 && isLocalBaseURL(cfg.BaseURL) {
+		keys, err := devJWTKeys()
+		if err != nil {
+			return nil, err
+		}
+		auth.JWTKeys = keys
+		log.Warnf("JWT_KEYS не задан: токены подписываются случайным ключом и перестанут приниматься "+
+			"после перезапуска. Так можно запускать только локально (%s), в остальных окружениях задайте JWT_KEYS", cfg.BaseURL)
+	}
+
+	tokens, err := makeTokenIssuer(auth)
+	if err != nil {
+		return nil, err
 	}
+
+	cookie := cfg.Cookie
+	if cookie == (config.Cookie{}) {
+		cookie = config.DefaultCookie(cfg.BaseURL, tokens.ttl)
+	}
+
+	maxBodySize := cfg.MaxRequestBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = config.DefaultMaxRequestBodySize
+	}
+
+	return &Middleware{
+		log:         log,
+		tokens:      tokens,
+		cookie:      cookie,
+		apiKeys:     apiKeys,
+		maxBodySize: maxBodySize,
+
+		createLimit:   makeRateLimiter(cfg.CreateLimit),
+		redirectLimit: makeRateLimiter(cfg.RedirectLimit),
+		issueLimit:    makeRateLimiter(cfg.IssueLimit),
+	}, nil
 }
 
+// WithRequestID берёт id запроса из заголовка requestid.Header или, если клиент его не прислал
+// или прислал недопустимый, выдаёт новый. Id кладётся в контекст и возвращается в том же заголовке ответа,
+// чтобы по жалобе клиента можно было найти записи лога о его запросе.
+func (m *Middleware) WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		res.Header().Set(requestid.Header, id)
+		next.ServeHTTP(res, req.WithContext(requestid.WithID(req.Context(), id)))
+	})
+}
+
+// WithLog должна стоять после WithRequestID. Она заводит логгер запроса с его id
+// и после ответа пишет итоговую запись о запросе. Следующие middleware и обработчики
+// берут логгер из контекста через logger.FromContext.
 func (m *Middleware) WithLog(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		start := time.Now()
@@ -24,6 +96,9 @@ func (m *Middleware) WithLog(next http.Handler) http.Handler {
 		uri := req.RequestURI
 		method := req.Method
 
+		ctx := logger.WithLogger(req.Context(), m.log.With(logger.FieldRequestID, requestid.FromContext(req.Context())))
+		req = req.WithContext(ctx)
+
 		responseData := &responseData{
 			status: 0,
 			size:   0,
@@ -37,7 +112,8 @@ func (m *Middleware) WithLog(next http.Handler) http.Handler {
 
 		duration := time.Since(start)
 
-		m.log.Infoln(
+		logger.FromContext(ctx).Infow(
+			"Запрос обработан",
 			"uri", uri,
 			"method", method,
 			"duration", duration,
@@ -63,17 +139,31 @@ func (m *Middleware) WithGzipResp(next http.Handler) http.Handler {
 	})
 }
 
+// WithBodyLimit ограничивает тело запроса. Чтение сверх лимита возвращает *http.MaxBytesError,
+// на которую обработчики отвечают 413.
+func (m *Middleware) WithBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		req.Body = http.MaxBytesReader(res, req.Body, m.maxBodySize)
+		next.ServeHTTP(res, req)
+	})
+}
+
 func (m *Middleware) WithGzipReq(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		if strings.Contains(req.Header.Get("Content-Encoding"), "gzip") {
 			gzipR, err := NewGzipReader(req.Body)
 			if err != nil {
-				res.WriteHeader(http.StatusInternalServerError)
+				logger.FromContext(req.Context()).Infow("Не удалось распаковать тело запроса", logger.FieldError, err)
+				apierror.Write(res, req, http.StatusBadRequest, apierror.Error{
+					Code:    apierror.CodeInvalidEncoding,
+					Message: "request body is not valid gzip",
+				})
 				return
 			}
 			defer gzipR.Close()
 
-			req.Body = gzipR
+			// Лимит WithBodyLimit действует на сжатое тело, распакованное ограничиваем ещё раз.
+			req.Body = http.MaxBytesReader(res, gzipR, m.maxBodySize)
 		}
 
 		next.ServeHTTP(res, req)
@@ -83,8 +173,11 @@ func (m *Middleware) WithGzipReq(next http.Handler) http.Handler {
 func (m *Middleware) WithJSONReqCheck(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		if !strings.Contains(req.Header.Get("Content-Type"), "application/json") {
-			m.log.Infoln("Ожидался json тип запроса")
-			res.WriteHeader(http.StatusBadRequest)
+			logger.FromContext(req.Context()).Infow("Ожидался json тип запроса", "content_type", req.Header.Get("Content-Type"))
+			apierror.Write(res, req, http.StatusUnsupportedMediaType, apierror.Error{
+				Code:    apierror.CodeUnsupportedMediaType,
+				Message: "content type must be application/json",
+			})
 			return
 		}
 
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithLogRequestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	m := makeTestMiddleware(t, config.Auth{})
+	m.log = zap.New(core).Sugar()
+
+	h := m.WithRequestID(m.WithLog(m.WithAuth(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		logger.FromContext(req.Context()).Errorw("Не удалось сохранить url", logger.FieldError, "boom")
+		res.WriteHeader(http.StatusCreated)
+	}))))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(requestid.Header, "gateway-42")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Не удалось сохранить url", entries[0].Message)
+	assert.Equal(t, "Запрос обработан", entries[1].Message)
+
+	// Обе записи несут id запроса и пользователя, хотя итоговую пишет WithLog, стоящая до WithAuth.
+	userID := entries[0].ContextMap()[logger.FieldUserID]
+	assert.NotEmpty(t, userID)
+	for _, e := range entries {
+		assert.Equal(t, "gateway-42", e.ContextMap()[logger.FieldRequestID], e.Message)
+		assert.Equal(t, userID, e.ContextMap()[logger.FieldUserID], e.Message)
+	}
+	assert.EqualValues(t, http.StatusCreated, entries[1].ContextMap()["status"])
+}
+
+func TestWithRequestID(t *testing.T) {
+	m := makeTestMiddleware(t, config.Auth{})
+
+	var got string
+	h := m.WithRequestID(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		got = requestid.FromContext(req.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		wantSame bool
+	}{
+		{name: "accepted", header: "gateway-42", wantSame: true},
+		{name: "missing", header: ""},
+		{name: "invalid", header: "bad id\r\nSet-Cookie: x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(requestid.Header, tt.header)
+			}
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			assert.True(t, requestid.Valid(got))
+			assert.Equal(t, got, res.Header().Get(requestid.Header), "id возвращается в ответе")
+			if tt.wantSame {
+				assert.Equal(t, tt.header, got)
+			} else {
+				assert.NotEqual(t, tt.header, got)
+			}
+		})
+	}
+}
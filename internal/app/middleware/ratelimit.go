@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+)
+
+// rateLimitSweepInterval — как часто rateLimiter забывает клиентов с полной корзиной.
+const rateLimitSweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter — корзины маркеров по клиентам. Корзина наполняется со скоростью limit/period
+// и вмещает limit маркеров. Клиент с полной корзиной ничем не отличается от нового, поэтому
+// такие корзины периодически удаляются, чтобы память не росла с числом клиентов.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     float64
+	rate      float64 // маркеров в секунду
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// makeRateLimiter возвращает nil для нулевого бюджета: такой лимит не ограничивает запросы.
+func makeRateLimiter(b config.RateBudget) *rateLimiter {
+	if b.Limit <= 0 || b.Period <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		limit:   float64(b.Limit),
+		rate:    float64(b.Limit) / b.Period.Seconds(),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// rateDecision — результат проверки запроса для заголовков ответа.
+type rateDecision struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// reset — через сколько корзина наполнится целиком.
+	reset time.Duration
+	// retryAfter — через сколько появится маркер для следующего запроса, если запрос отклонён.
+	retryAfter time.Duration
+}
+
+// allow забирает по маркеру из корзин всех keys, если маркер есть в каждой.
+// Решение описывает самую пустую из корзин.
+func (l *rateLimiter) allow(now time.Time, keys ...string) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	buckets := make([]*bucket, len(keys))
+	minTokens := l.limit
+	for i, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: l.limit, last: now}
+			l.buckets[key] = b
+		}
+		b.tokens = l.refill(b, now)
+		b.last = now
+		buckets[i] = b
+		minTokens = math.Min(minTokens, b.tokens)
+	}
+
+	d := rateDecision{limit: int(l.limit)}
+	if minTokens >= 1 {
+		for _, b := range buckets {
+			b.tokens--
+		}
+		minTokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = l.wait(1 - minTokens)
+	}
+	d.remaining = int(minTokens)
+	d.reset = l.wait(l.limit - minTokens)
+	return d
+}
+
+func (l *rateLimiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.limit, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// wait — время, за которое в корзину добавится n маркеров.
+func (l *rateLimiter) wait(n float64) time.Duration {
+	return time.Duration(n / l.rate * float64(time.Second))
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.limit {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// WithCreateRateLimit ограничивает создание ссылок бюджетом config.RateLimit.CreateLimit.
+func (m *Middleware) WithCreateRateLimit(next http.Handler) http.Handler {
+	return m.withRateLimit(next, m.createLimit)
+}
+
+// WithRedirectRateLimit ограничивает переходы по ссылкам бюджетом config.RateLimit.RedirectLimit.
+func (m *Middleware) WithRedirectRateLimit(next http.Handler) http.Handler {
+	return m.withRateLimit(next, m.redirectLimit)
+}
+
+// withRateLimit должен стоять после WithAuth или WithOptionalAuth. Запрос расходует корзину ip-адреса клиента и, если
+// пользователь известен, корзину пользователя: без первой клиент обходил бы лимит, заводя себе
+// новых пользователей, без второй — пользователь, приходя с разных адресов.
+// Заголовки RateLimit-* следуют черновику IETF draft-ietf-httpapi-ratelimit-headers.
+func (m *Middleware) withRateLimit(next http.Handler, l *rateLimiter) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		d := l.allow(time.Now(), rateLimitKeys(req)...)
+
+		res.Header().Set("RateLimit-Limit", strconv.Itoa(d.limit))
+		res.Header().Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+		res.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+
+		if !d.allowed {
+			rateLimited(res, req, d)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// rateLimited отвечает 429 на запрос, отклонённый решением d.
+func rateLimited(res http.ResponseWriter, req *http.Request, d rateDecision) {
+	logger.FromContext(req.Context()).Infow("Превышен лимит запросов", "uri", req.RequestURI)
+	res.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d.retryAfter)))
+	apierror.Write(res, req, http.StatusTooManyRequests, apierror.Error{
+		Code:    apierror.CodeRateLimited,
+		Message: "rate limit exceeded",
+	})
+}
+
+// rateLimitKeys — корзины запроса: ip-адреса и, если он известен, пользователя.
+func rateLimitKeys(req *http.Request) []string {
+	keys := []string{ipRateLimitKey(req)}
+	if userID, ok := req.Context().Value(config.UserIDKeyName).(string); ok {
+		keys = append(keys, "user:"+userID)
+	}
+	return keys
+}
+
+func ipRateLimitKey(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	return "ip:" + ip
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := makeRateLimiter(config.RateBudget{Limit: 2, Period: 2 * time.Second})
+	now := time.Now()
+
+	d := l.allow(now, "a")
+	assert.True(t, d.allowed)
+	assert.Equal(t, 1, d.remaining)
+	assert.Equal(t, time.Second, d.reset)
+
+	assert.True(t, l.allow(now, "a").allowed)
+
+	d = l.allow(now, "a")
+	assert.False(t, d.allowed)
+	assert.Equal(t, 0, d.remaining)
+	assert.Equal(t, time.Second, d.retryAfter)
+	assert.Equal(t, 2*time.Second, d.reset)
+
+	// У другого клиента своя корзина.
+	assert.True(t, l.allow(now, "b").allowed)
+
+	// Запрос с несколькими корзинами проходит, только если маркер есть в каждой, и тогда расходует все.
+	assert.False(t, l.allow(now, "b", "a").allowed)
+	assert.Equal(t, 1, l.allow(now, "c").remaining, "отклонённый запрос не расходует корзины")
+	d = l.allow(now, "b", "c")
+	assert.True(t, d.allowed)
+	assert.Equal(t, 0, d.remaining)
+
+	// За секунду корзина пополнилась на один маркер.
+	assert.True(t, l.allow(now.Add(time.Second), "a").allowed)
+	assert.False(t, l.allow(now.Add(time.Second), "a").allowed)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := makeRateLimiter(config.RateBudget{Limit: 1, Period: time.Second})
+	now := time.Now()
+
+	l.allow(now, "a")
+	l.allow(now.Add(rateLimitSweepInterval), "b")
+	assert.Len(t, l.buckets, 1, "корзина a успела наполниться и должна быть удалена")
+}
+
+func TestMakeRateLimiterDisabled(t *testing.T) {
+	assert.Nil(t, makeRateLimiter(config.RateBudget{}))
+	assert.Nil(t, makeRateLimiter(config.RateBudget{Limit: 10}))
+}
+
+func TestWithRateLimit(t *testing.T) {
+	m := makeTestMiddleware(t, config.Auth{})
+	m.createLimit = makeRateLimiter(config.RateBudget{Limit: 1, Period: time.Minute})
+	token, err := m.tokens.issue("user", time.Now())
+	require.NoError(t, err)
+
+	h := m.WithAuth(m.WithCreateRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})))
+	serve := func(remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve("10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusCreated, res.Code)
+	assert.Equal(t, "1", res.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", res.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", res.Header().Get("RateLimit-Reset"))
+
+	// Без учётных данных клиент каждый раз получает нового пользователя, но корзина ip у него одна.
+	res = serve("10.0.0.1:4321", "")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":"rate_limited","message":"rate limit exceeded"}`, res.Body.String())
+
+	// Выданный только что токен тоже не даёт нового бюджета.
+	issued := res.Header().Get(IssuedTokenHeader)
+	require.NotEmpty(t, issued)
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", issued).Code)
+
+	// Пользователь расходует свою корзину, с какого бы адреса он ни пришёл.
+	assert.Equal(t, http.StatusCreated, serve("10.0.0.2:1234", token).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.3:1234", token).Code)
+	assert.Equal(t, http.StatusCreated, serve("10.0.0.3:1234", "").Code)
+}
+
+func TestWithAuthIssueRateLimit(t *testing.T) {
+	m := makeTestMiddleware(t, config.Auth{})
+	m.issueLimit = makeRateLimiter(config.RateBudget{Limit: 1, Period: time.Minute})
+	token, err := m.tokens.issue("user", time.Now())
+	require.NoError(t, err)
+
+	h := m.WithAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(remoteAddr, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = remoteAddr
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: string(config.UserIDKeyName), Value: cookie})
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "").Code)
+	res := serve("10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Empty(t, res.Result().Cookies())
+	// Непригодная cookie тоже означает выдачу нового токена.
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", "invalid.token.string").Code)
+
+	// Клиентов с действующим токеном и с других адресов лимит не касается.
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", token).Code)
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:1234", "").Code)
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "[::1]:8080"
+	assert.Equal(t, []string{"ip:::1"}, rateLimitKeys(req))
+
+	req = req.WithContext(context.WithValue(req.Context(), config.UserIDKeyName, "user"))
+	assert.Equal(t, []string{"ip:::1", "user:user"}, rateLimitKeys(req))
+}
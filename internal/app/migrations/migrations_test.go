@@ -0,0 +1,144 @@
+package migrations
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := load(files)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.EqualValues(t, i+1, m.Version, "версии миграций должны идти подряд")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+}
+
+func TestLoadRejectsBrokenSets(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "missing_down",
+			fsys: fstest.MapFS{
+				"sql/0001_init.up.sql": {Data: []byte("SELECT 1;")},
+			},
+		},
+		{
+			name: "bad_name",
+			fsys: fstest.MapFS{
+				"sql/init.sql": {Data: []byte("SELECT 1;")},
+			},
+		},
+		{
+			name: "name_mismatch",
+			fsys: fstest.MapFS{
+				"sql/0001_init.up.sql":    {Data: []byte("SELECT 1;")},
+				"sql/0001_other.down.sql": {Data: []byte("SELECT 1;")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func makeTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	migrations, err := load(fstest.MapFS{
+		"sql/0001_first.up.sql":    {Data: []byte("CREATE TABLE first ();")},
+		"sql/0001_first.down.sql":  {Data: []byte("DROP TABLE first;")},
+		"sql/0002_second.up.sql":   {Data: []byte("CREATE TABLE second ();")},
+		"sql/0002_second.down.sql": {Data: []byte("DROP TABLE second;")},
+	})
+	require.NoError(t, err)
+
+	return &Migrator{db: db, migrations: migrations}, mock
+}
+
+func expectLocked(mock sqlmock.Sqlmock, applied ...int64) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).
+		WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range applied {
+		rows.AddRow(v, time.Now())
+	}
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func expectUnlocked(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).
+		WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestUpAppliesOnlyPending(t *testing.T) {
+	m, mock := makeTestMigrator(t)
+
+	expectLocked(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE second ();`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).
+		WithArgs(int64(2), "second").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlocked(mock)
+
+	n, err := m.Up(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDownRollsBackLatest(t *testing.T) {
+	m, mock := makeTestMigrator(t)
+
+	expectLocked(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DROP TABLE second;`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM schema_migrations`).
+		WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlocked(mock)
+
+	migration, err := m.Down(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, migration)
+	assert.EqualValues(t, 2, migration.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStatus(t *testing.T) {
+	m, mock := makeTestMigrator(t)
+
+	expectLocked(mock, 1)
+	expectUnlocked(mock)
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.False(t, statuses[0].AppliedAt.IsZero())
+	assert.True(t, statuses[1].AppliedAt.IsZero())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
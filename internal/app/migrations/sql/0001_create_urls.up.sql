@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS urls (
+    uuid UUID PRIMARY KEY,
+    short_url VARCHAR(255) NOT NULL UNIQUE,
+    original_url TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_short_url ON urls (short_url);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_user_uuid;
+
+ALTER TABLE urls DROP COLUMN IF EXISTS user_uuid;
@@ -0,0 +1,3 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS user_uuid UUID;
+
+CREATE INDEX IF NOT EXISTS idx_user_uuid ON urls (user_uuid);
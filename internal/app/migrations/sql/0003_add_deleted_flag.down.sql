@@ -0,0 +1 @@
+ALTER TABLE urls DROP COLUMN IF EXISTS deleted_flag;
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_flag integer DEFAULT 0;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_original_url ON urls USING hash (original_url);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_original_url_unique;
+
+ALTER TABLE urls DROP COLUMN IF EXISTS is_alias;
@@ -0,0 +1,10 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS is_alias BOOLEAN NOT NULL DEFAULT false;
+
+-- Из живых ссылок на один url основной остаётся одна, остальные становятся псевдонимами.
+UPDATE urls SET is_alias = true
+WHERE deleted_flag = 0 AND uuid NOT IN (
+    SELECT DISTINCT ON (original_url) uuid FROM urls WHERE deleted_flag = 0 ORDER BY original_url, uuid
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url_unique ON urls (md5(original_url))
+    WHERE deleted_flag = 0 AND NOT is_alias;
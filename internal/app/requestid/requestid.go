@@ -0,0 +1,48 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Header — заголовок запроса и ответа с id запроса.
+const Header = "X-Request-ID"
+
+// maxLen ограничивает длину id, присланного клиентом: он попадает в каждую запись лога.
+const maxLen = 128
+
+type ctxKey struct{}
+
+// New выдаёт новый id запроса.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid проверяет id, присланный клиентом: непустой, не длиннее maxLen и только из букв,
+// цифр и символов "-_.:", чтобы его нельзя было использовать для подделки строк лога или заголовков.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithID кладёт id запроса в контекст.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext возвращает id запроса или пустую строку вне запроса.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
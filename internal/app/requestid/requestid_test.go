@@ -0,0 +1,25 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(New()))
+	assert.True(t, Valid("gateway-1:abc_DEF.42"))
+
+	assert.False(t, Valid(""))
+	assert.False(t, Valid(strings.Repeat("a", maxLen+1)))
+	assert.False(t, Valid("abc\ninjected"))
+	assert.False(t, Valid("abc def"))
+	assert.False(t, Valid(`"quoted"`))
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Empty(t, FromContext(context.Background()))
+	assert.Equal(t, "abc", FromContext(WithID(context.Background(), "abc")))
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/apierror"
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/handlers"
+	"github.com/eduardtungatarov/shortener/internal/app/keygen"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/middleware"
+	"github.com/eduardtungatarov/shortener/internal/app/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMaxBodySize — лимит тела запроса в TestAPIErrors.
+const testMaxBodySize = 256
+
+// brokenStorage не может сохранить ссылку, которая ведёт на broken.example.
+type brokenStorage struct {
+	*mockStorage
+}
+
+func (s brokenStorage) Set(ctx context.Context, key, value string, expiresAt time.Time) error {
+	if strings.Contains(value, "broken.example") {
+		return errors.New("connection reset")
+	}
+	return s.mockStorage.Set(ctx, key, value, expiresAt)
+}
+
+func gzipBody(t *testing.T, body string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.String()
+}
+
+// TestAPIErrors перечисляет коды ошибок API и запросы, на которые они возвращаются.
+// Каждая ошибка /api/* приходит телом {"code","message","details","request_id"}.
+func TestAPIErrors(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	s := brokenStorage{makeMockStorage()}
+	s.m["taken"] = "https://ya.ru/"
+
+	m, err := middleware.MakeMiddleware(config.Config{
+		Auth:               config.Auth{JWTKeys: []config.JWTKey{{ID: "test", Secret: "test-secret"}}},
+		MaxRequestBodySize: testMaxBodySize,
+	}, nil, log)
+	require.NoError(t, err)
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7),
+		makeDomainList(t, "deny evil.example\n"), config.DeleteQueue{}, config.Clicks{}, log)
+
+	ts := httptest.NewServer(getRouter(h, m, nil))
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		contentType     string
+		contentEncoding string
+		body            string
+		anonymous       bool
+		status          int
+		code            string
+		details         map[string]any
+	}{
+		{
+			name:        "malformed json",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidJSON,
+			details:     map[string]any{"reason": "unexpected EOF"},
+		},
+		{
+			name:        "batch is not an array",
+			method:      http.MethodPost,
+			path:        "/api/shorten/batch",
+			contentType: "application/json",
+			body:        `{"original_url":"https://ya.ru/"}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidJSON,
+		},
+		{
+			name:        "delete body is not a list of keys",
+			method:      http.MethodDelete,
+			path:        "/api/user/urls",
+			contentType: "application/json",
+			body:        `"abc"`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidJSON,
+		},
+		{
+			name:            "broken gzip",
+			method:          http.MethodPost,
+			path:            "/api/shorten",
+			contentType:     "application/json",
+			contentEncoding: "gzip",
+			body:            `{"url":"https://ya.ru/"}`,
+			status:          http.StatusBadRequest,
+			code:            apierror.CodeInvalidEncoding,
+		},
+		{
+			name:        "missing url",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidRequest,
+			details:     map[string]any{"field": "url"},
+		},
+		{
+			name:    "invalid stats period",
+			method:  http.MethodGet,
+			path:    "/api/user/urls/abc/stats?from=yesterday",
+			status:  http.StatusBadRequest,
+			code:    apierror.CodeInvalidRequest,
+			details: map[string]any{"field": "from"},
+		},
+		{
+			name:        "missing api key name",
+			method:      http.MethodPost,
+			path:        "/api/user/keys",
+			contentType: "application/json",
+			body:        `{}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidRequest,
+			details:     map[string]any{"field": "name"},
+		},
+		{
+			name:        "invalid url",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":"ftp://ya.ru/"}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidURL,
+		},
+		{
+			name:        "blocked domain",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":"https://evil.example/"}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeDomainNotAllowed,
+		},
+		{
+			name:        "invalid alias",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":"https://ya.ru/","alias":"no spaces"}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidAlias,
+		},
+		{
+			name:        "invalid expiry",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":"https://ya.ru/","ttl_seconds":-1}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidExpiry,
+		},
+		{
+			name:        "ttl overflows duration",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":"https://ya.ru/","ttl_seconds":9300000000}`,
+			status:      http.StatusBadRequest,
+			code:        apierror.CodeInvalidExpiry,
+		},
+		{
+			name:      "no credentials",
+			method:    http.MethodGet,
+			path:      "/api/user/urls",
+			anonymous: true,
+			status:    http.StatusUnauthorized,
+			code:      apierror.CodeUnauthorized,
+		},
+		{
+			name:   "unknown api key",
+			method: http.MethodDelete,
+			path:   "/api/user/keys/unknown",
+			status: http.StatusNotFound,
+			code:   apierror.CodeNotFound,
+		},
+		{
+			name:        "alias taken",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":"https://ya.ru/","alias":"taken"}`,
+			status:      http.StatusConflict,
+			code:        apierror.CodeAliasTaken,
+		},
+		{
+			name:        "not json content type",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "text/plain",
+			body:        `{"url":"https://ya.ru/"}`,
+			status:      http.StatusUnsupportedMediaType,
+			code:        apierror.CodeUnsupportedMediaType,
+		},
+		{
+			name:        "body too large",
+			method:      http.MethodPost,
+			path:        "/api/shorten/batch",
+			contentType: "application/json",
+			body:        `[{"correlation_id":"1","original_url":"https://ya.ru/` + strings.Repeat("a", testMaxBodySize) + `"}]`,
+			status:      http.StatusRequestEntityTooLarge,
+			code:        apierror.CodeBodyTooLarge,
+		},
+		{
+			name:            "body too large after decompression",
+			method:          http.MethodPost,
+			path:            "/api/shorten",
+			contentType:     "application/json",
+			contentEncoding: "gzip",
+			body:            gzipBody(t, `{"url":"https://ya.ru/`+strings.Repeat("a", 4*testMaxBodySize)+`"}`),
+			status:          http.StatusRequestEntityTooLarge,
+			code:            apierror.CodeBodyTooLarge,
+		},
+		{
+			name:        "storage failure",
+			method:      http.MethodPost,
+			path:        "/api/shorten",
+			contentType: "application/json",
+			body:        `{"url":"https://broken.example/"}`,
+			status:      http.StatusInternalServerError,
+			code:        apierror.CodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set(requestid.Header, testRequestID)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+			if !tt.anonymous {
+				req.Header.Set("Authorization", "Bearer "+makeToken(t, "user"))
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			var body struct {
+				Code      string         `json:"code"`
+				Message   string         `json:"message"`
+				Details   map[string]any `json:"details"`
+				RequestID string         `json:"request_id"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tt.code, body.Code)
+			assert.NotEmpty(t, body.Message)
+			assert.Equal(t, testRequestID, body.RequestID)
+			if tt.details != nil {
+				assert.Equal(t, tt.details, body.Details)
+			}
+		})
+	}
+
+	t.Run("internal error hides the cause", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten", strings.NewReader(`{"url":"https://broken.example/"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body apierror.Error
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.NotContains(t, body.Message, "connection reset")
+		assert.Equal(t, resp.Header.Get(requestid.Header), body.RequestID, "сгенерированный id тоже попадает в тело")
+	})
+}
@@ -1,51 +1,143 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
 	"github.com/eduardtungatarov/shortener/internal/app/handlers"
+	"github.com/eduardtungatarov/shortener/internal/app/metrics"
 	"github.com/eduardtungatarov/shortener/internal/app/middleware"
+	"github.com/eduardtungatarov/shortener/internal/app/tracing"
 	"github.com/go-chi/chi/v5"
+	"net"
 	"net/http"
+	"time"
 )
 
-func Run(cfg config.Config, h *handlers.Handler, m *middleware.Middleware) error {
-	r := getRouter(h, m)
-	return http.ListenAndServe(cfg.ServerHostPort, r)
+// ErrServe — сервер перестал принимать соединения до остановки. Его отличают от ошибок
+// самой остановки, которые возвращаются вместе с ним.
+var ErrServe = errors.New("server stopped accepting connections")
+
+// Listen занимает адрес cfg.ServerHostPort. Его вызывают до запуска фоновых задач обработчика,
+// чтобы занятый адрес не оставлял их работать без сервера.
+func Listen(cfg config.Config) (net.Listener, error) {
+	return net.Listen("tcp", cfg.ServerHostPort)
+}
+
+// Run обслуживает запросы на ln до отмены ctx, а затем корректно останавливает сервер: см. serve.
+func Run(ctx context.Context, ln net.Listener, cfg config.Config, h *handlers.Handler, m *middleware.Middleware, mx *metrics.Metrics) error {
+	srv := &http.Server{
+		Handler: getRouter(h, m, mx),
+	}
+	return serve(ctx, srv, ln, cfg.ShutdownTimeout, h)
+}
+
+// serve принимает запросы на ln до отмены ctx. При остановке сервер перестаёт принимать
+// новые соединения, дожидается начатых запросов, после чего h сохраняет свои очереди.
+// На всю остановку отводится timeout.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration, h *handlers.Handler) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	// Serve возвращает ошибку до Shutdown, только если не смог принимать соединения.
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+		err = fmt.Errorf("%w: %w", ErrServe, err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	return errors.Join(err, srv.Shutdown(shutdownCtx), h.Close(shutdownCtx))
 }
 
-func getRouter(h *handlers.Handler, m *middleware.Middleware) chi.Router {
+// getRouter собирает маршруты сервиса. Без mx сервис работает без метрик и маршрута /metrics.
+// Каждая middleware и каждый обработчик получают свой спан; пока трассировка не включена, спаны ничего не стоят.
+func getRouter(h *handlers.Handler, m *middleware.Middleware, mx *metrics.Metrics) chi.Router {
+	mw := tracing.Middleware
+	handle := tracing.Handler
+
 	r := chi.NewRouter()
-	r.Use(m.WithLog, m.WithAuth)
+	r.Use(tracing.WithRequests, mw("WithRequestID", m.WithRequestID))
+	if mx != nil {
+		r.Use(mw("WithRequests", mx.WithRequests))
+	}
+	r.Use(mw("WithLog", m.WithLog), mw("WithBodyLimit", m.WithBodyLimit))
 
-	r.Get(
-		"/{shortUrl}",
-		h.HandleGet,
-	)
+	if mx != nil {
+		r.Handle("/metrics", mx.Handler())
+	}
 
-	r.Get(
-		"/ping",
-		h.HandleGetPing,
-	)
+	// Переходы по ссылкам и проверка доступности не заводят новых пользователей.
+	r.Group(func(r chi.Router) {
+		r.Use(mw("WithOptionalAuth", m.WithOptionalAuth))
 
-	r.Get(
-		"/api/user/urls",
-		h.HandleGetUserUrls,
-	)
+		r.With(mw("WithRedirectRateLimit", m.WithRedirectRateLimit)).Get(
+			"/{shortUrl}",
+			handle("HandleGet", h.HandleGet),
+		)
 
-	gzipReqG := r.Group(func(r chi.Router) {
-		r.Use(m.WithGzipReq)
+		r.Get(
+			"/ping",
+			handle("HandleGetPing", h.HandleGetPing),
+		)
 	})
-	gzipReqG.Post(
-		"/",
-		h.HandlePost,
-	)
-	gzipReqG.Group(func(r chi.Router) {
-		r.Use(m.WithGzipResp, m.WithJSONReqCheck)
-		r.Post("/api/shorten", h.HandleShorten)
-		r.Post("/api/shorten/batch", h.HandleShortenBatch)
-		r.Delete(
+
+	// Создание ссылок: новый пользователь получает токен при первом обращении.
+	r.Group(func(r chi.Router) {
+		r.Use(mw("WithAuth", m.WithAuth))
+
+		gzipReqG := r.Group(func(r chi.Router) {
+			r.Use(mw("WithCreateRateLimit", m.WithCreateRateLimit), mw("WithGzipReq", m.WithGzipReq))
+		})
+		gzipReqG.Post(
+			"/",
+			handle("HandlePost", h.HandlePost),
+		)
+		gzipReqG.Group(func(r chi.Router) {
+			r.Use(mw("WithGzipResp", m.WithGzipResp), mw("WithJSONReqCheck", m.WithJSONReqCheck))
+			r.Post("/api/shorten", handle("HandleShorten", h.HandleShorten))
+			r.Post("/api/shorten/batch", handle("HandleShortenBatch", h.HandleShortenBatch))
+		})
+	})
+
+	// Ссылки пользователя доступны только с уже выданным токеном.
+	r.Group(func(r chi.Router) {
+		r.Use(mw("WithRequiredAuth", m.WithRequiredAuth))
+
+		r.Get(
+			"/api/user/urls",
+			handle("HandleGetUserUrls", h.HandleGetUserUrls),
+		)
+
+		r.Get(
+			"/api/user/urls/{key}/stats",
+			handle("HandleGetURLStats", h.HandleGetURLStats),
+		)
+
+		r.With(mw("WithGzipReq", m.WithGzipReq), mw("WithGzipResp", m.WithGzipResp), mw("WithJSONReqCheck", m.WithJSONReqCheck)).Delete(
 			"/api/user/urls",
-			h.HandleDeleteUserUrls,
+			handle("HandleDeleteUserUrls", h.HandleDeleteUserUrls),
+		)
+
+		r.Get(
+			"/api/user/keys",
+			handle("HandleGetAPIKeys", h.HandleGetAPIKeys),
+		)
+
+		r.With(mw("WithJSONReqCheck", m.WithJSONReqCheck)).Post(
+			"/api/user/keys",
+			handle("HandleCreateAPIKey", h.HandleCreateAPIKey),
+		)
+
+		r.Delete(
+			"/api/user/keys/{id}",
+			handle("HandleRevokeAPIKey", h.HandleRevokeAPIKey),
 		)
 	})
 
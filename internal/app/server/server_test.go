@@ -4,52 +4,85 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/domainlist"
 	"github.com/eduardtungatarov/shortener/internal/app/handlers"
+	"github.com/eduardtungatarov/shortener/internal/app/keygen"
 	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/metrics"
 	"github.com/eduardtungatarov/shortener/internal/app/middleware"
 	"github.com/eduardtungatarov/shortener/internal/app/mocks"
+	"github.com/eduardtungatarov/shortener/internal/app/requestid"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
+// testRequestID — id запроса, который тесты присылают в requestid.Header и ждут в ответах с ошибкой.
+const testRequestID = "test-request-id"
+
 type mockStorage struct {
 	m         map[string]string
+	expiresAt map[string]time.Time
 	userLinks map[string][]string
 }
 
 func makeMockStorage() *mockStorage {
 	return &mockStorage{
 		m:         make(map[string]string),
+		expiresAt: make(map[string]time.Time),
 		userLinks: make(map[string][]string),
 	}
 }
 
-func (s *mockStorage) Set(ctx context.Context, key, value string) error {
+func (s *mockStorage) Set(ctx context.Context, key, value string, expiresAt time.Time) error {
+	for k, v := range s.m {
+		if v == value {
+			return &storage.KeyError{Key: k, Err: storage.ErrConflict}
+		}
+	}
 	s.m[key] = value
+	s.expiresAt[key] = expiresAt
 	return nil
 }
 
-func (s *mockStorage) SetBatch(ctx context.Context, keyValues map[string]string) error {
-	for key, originalURL := range keyValues {
-		err := s.Set(ctx, key, originalURL)
-		if err != nil {
-			return err
-		}
+func (s *mockStorage) SetAlias(ctx context.Context, alias, value string, expiresAt time.Time) error {
+	if _, ok := s.m[alias]; ok {
+		return &storage.KeyError{Key: alias, Err: storage.ErrAliasTaken}
 	}
+	s.m[alias] = value
+	s.expiresAt[alias] = expiresAt
 	return nil
 }
 
+func (s *mockStorage) SetBatch(ctx context.Context, links []storage.Link) ([]error, error) {
+	errs := make([]error, len(links))
+	for i, l := range links {
+		errs[i] = s.Set(ctx, l.ShortURL, l.OriginalURL, l.ExpiresAt)
+	}
+	return errs, nil
+}
+
 func (s *mockStorage) Get(ctx context.Context, key string) (string, error) {
 	v, ok := s.m[key]
 	if !ok {
-		return "", errors.New("not found")
+		return "", storage.ErrNotFound
+	}
+	if exp := s.expiresAt[key]; !exp.IsZero() && exp.Before(time.Now()) {
+		return "", storage.ErrExpired
 	}
 	return v, nil
 }
@@ -58,14 +91,94 @@ func (s *mockStorage) GetByUserID(ctx context.Context) ([]map[string]string, err
 	return make([]map[string]string, 0), nil
 }
 
-func (s *mockStorage) DeleteBatch(ctx context.Context, keys []string, userID string) error {
+func (s *mockStorage) GetClickStats(ctx context.Context, key string, from, to time.Time) (storage.ClickStats, error) {
+	return storage.ClickStats{}, nil
+}
+
+func (s *mockStorage) DeleteBatch(ctx context.Context, deletions []storage.Deletion) error {
 	return nil
 }
 
+func (s *mockStorage) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (s *mockStorage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	return nil
+}
+
+func (s *mockStorage) SaveAPIKey(ctx context.Context, key storage.APIKey) error {
+	return nil
+}
+
+func (s *mockStorage) GetAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	return nil, nil
+}
+
+func (s *mockStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	return storage.ErrNotFound
+}
+
 func (s *mockStorage) Ping(ctx context.Context) error {
 	return nil
 }
 
+func makeMiddleware(t *testing.T, apiKeys middleware.APIKeyResolver, log *zap.SugaredLogger) *middleware.Middleware {
+	m, err := middleware.MakeMiddleware(config.Config{
+		Auth: config.Auth{
+			JWTKeys: []config.JWTKey{{ID: "test", Secret: "test-secret"}},
+		},
+	}, apiKeys, log)
+	require.NoError(t, err)
+	return m
+}
+
+// makeToken подписывает токен пользователя userID тестовым ключом из makeMiddleware.
+func makeToken(t *testing.T, userID string) string {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.DefaultJWTIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		UserID: userID,
+	})
+	token.Header["kid"] = "test"
+
+	s, err := token.SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+	return s
+}
+
+// makeDomainList собирает список доменов из текста файла правил; пустой текст — список без ограничений.
+func makeDomainList(t *testing.T, rules string) *domainlist.List {
+	path := ""
+	if rules != "" {
+		path = filepath.Join(t.TempDir(), "domains.txt")
+		require.NoError(t, os.WriteFile(path, []byte(rules), 0644))
+	}
+
+	l, err := domainlist.MakeList(path)
+	require.NoError(t, err)
+	return l
+}
+
+// condMatcher сопоставляет аргумент мока с произвольным условием.
+type condMatcher func(x any) bool
+
+func cond(fn func(x any) bool) gomock.Matcher {
+	return condMatcher(fn)
+}
+
+func (m condMatcher) Matches(x any) bool {
+	return m(x)
+}
+
+func (m condMatcher) String() string {
+	return "matches condition"
+}
+
 func TestServer(t *testing.T) {
 	type input struct {
 		preloadedStorage handlers.Storage
@@ -75,6 +188,8 @@ func TestServer(t *testing.T) {
 		acceptEncoding   string
 		contentEncoding  string
 		body             string
+		// userID — владелец Bearer-токена запроса, пустой — запрос без учётных данных.
+		userID string
 	}
 	type output struct {
 		statusCode             int
@@ -118,12 +233,59 @@ func TestServer(t *testing.T) {
 				response:            "http://localhost:8080/",
 			},
 		},
+		{
+			name: "post_normalizes_url",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					ctrl := gomock.NewController(t)
+					m := mocks.NewMockStorage(ctrl)
+					m.EXPECT().Set(gomock.Any(), "0dd1981", "https://practicum.yandex.ru/", time.Time{}).Return(nil)
+					return m
+				}(),
+				httpMethod:  "POST",
+				requestURI:  "/",
+				contentType: "text/plain",
+				body:        "HTTPS://Practicum.Yandex.RU:443",
+			},
+			output: output{
+				statusCode: 201,
+				response:   "http://localhost:8080/0dd1981",
+			},
+		},
+		{
+			name: "post_invalid_url",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/",
+				contentType:      "text/plain",
+				body:             "javascript:alert(1)",
+			},
+			output: output{
+				statusCode:             400,
+				contentTypeHeaderValue: "application/json",
+			},
+		},
+		{
+			name: "post_api_shorten_invalid_url",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/api/shorten",
+				contentType:      "application/json",
+				body:             `{"url":"practicum.yandex.ru"}`,
+			},
+			output: output{
+				statusCode:             400,
+				contentTypeHeaderValue: "application/json",
+			},
+		},
 		{
 			name: "success_get",
 			input: input{
 				preloadedStorage: func() handlers.Storage {
 					s := makeMockStorage()
-					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/")
+					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/", time.Time{})
 					return s
 				}(),
 				httpMethod:  "GET",
@@ -137,6 +299,66 @@ func TestServer(t *testing.T) {
 				response:            "",
 			},
 		},
+		{
+			name: "post_with_key_collision",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					ctrl := gomock.NewController(t)
+					m := mocks.NewMockStorage(ctrl)
+					gomock.InOrder(
+						m.EXPECT().Set(gomock.Any(), "0dd1981", "https://practicum.yandex.ru/", time.Time{}).
+							Return(&storage.KeyError{Key: "0dd1981", Err: storage.ErrKeyCollision}),
+						m.EXPECT().Set(gomock.Any(), "0dd19817", "https://practicum.yandex.ru/", time.Time{}).
+							Return(nil),
+					)
+					return m
+				}(),
+				httpMethod:  "POST",
+				requestURI:  "/",
+				contentType: "text/plain",
+				body:        "https://practicum.yandex.ru/",
+			},
+			output: output{
+				statusCode: 201,
+				response:   "http://localhost:8080/0dd19817",
+			},
+		},
+		{
+			name: "post_api_shorten_conflict_returns_stored_key",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					ctrl := gomock.NewController(t)
+					m := mocks.NewMockStorage(ctrl)
+					m.EXPECT().Set(gomock.Any(), gomock.Any(), "https://practicum.yandex.ru/", gomock.Any()).
+						Return(&storage.KeyError{Key: "stored1", Err: storage.ErrConflict})
+					return m
+				}(),
+				httpMethod:  "POST",
+				requestURI:  "/api/shorten",
+				contentType: "application/json",
+				body:        `{"url":"https://practicum.yandex.ru"}`,
+			},
+			output: output{
+				statusCode:             409,
+				contentTypeHeaderValue: "application/json",
+				response:               `{"result":"http://localhost:8080/stored1"}`,
+			},
+		},
+		{
+			name: "get_expired_shortUrl",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					s := makeMockStorage()
+					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/", time.Now().Add(-time.Minute))
+					return s
+				}(),
+				httpMethod: "GET",
+				requestURI: "/0dd1981",
+			},
+			output: output{
+				statusCode: 410,
+			},
+		},
 		{
 			name: "post_with_empty_body",
 			input: input{
@@ -172,7 +394,7 @@ func TestServer(t *testing.T) {
 			input: input{
 				preloadedStorage: func() handlers.Storage {
 					s := makeMockStorage()
-					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/")
+					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/", time.Time{})
 					return s
 				}(),
 				httpMethod:  "GET",
@@ -191,7 +413,7 @@ func TestServer(t *testing.T) {
 			input: input{
 				preloadedStorage: func() handlers.Storage {
 					s := makeMockStorage()
-					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/")
+					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/", time.Time{})
 					return s
 				}(),
 				httpMethod:  "GET",
@@ -200,17 +422,49 @@ func TestServer(t *testing.T) {
 				body:        "",
 			},
 			output: output{
-				statusCode:          400,
+				statusCode:          404,
 				locationHeaderValue: "",
 				response:            "",
 			},
 		},
+		{
+			name: "get_deleted_shortUrl",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					ctrl := gomock.NewController(t)
+					m := mocks.NewMockStorage(ctrl)
+					m.EXPECT().Get(gomock.Any(), "0dd1981").Return("", storage.ErrDeleted)
+					return m
+				}(),
+				httpMethod: "GET",
+				requestURI: "/0dd1981",
+			},
+			output: output{
+				statusCode: 410,
+			},
+		},
+		{
+			name: "get_with_storage_failure",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					ctrl := gomock.NewController(t)
+					m := mocks.NewMockStorage(ctrl)
+					m.EXPECT().Get(gomock.Any(), "0dd1981").Return("", errors.New("connection refused"))
+					return m
+				}(),
+				httpMethod: "GET",
+				requestURI: "/0dd1981",
+			},
+			output: output{
+				statusCode: 503,
+			},
+		},
 		{
 			name: "incorrect_method",
 			input: input{
 				preloadedStorage: func() handlers.Storage {
 					s := makeMockStorage()
-					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/")
+					s.Set(context.Background(), "0dd1981", "https://practicum.yandex.ru/", time.Time{})
 					return s
 				}(),
 				httpMethod:  "PATCH",
@@ -282,11 +536,124 @@ func TestServer(t *testing.T) {
 				body:             `{"url":"https://practicum.yandex.ru"}`,
 			},
 			output: output{
-				statusCode:             400,
+				statusCode:             415,
 				contentTypeHeaderValue: "",
 				response:               ``,
 			},
 		},
+		{
+			name: "success_post_api_shorten_with_alias",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/api/shorten",
+				contentType:      "application/json",
+				body:             `{"url":"https://practicum.yandex.ru","alias":"spring-sale"}`,
+			},
+			output: output{
+				statusCode:             201,
+				contentTypeHeaderValue: "application/json",
+				response:               `{"result":"http://localhost:8080/spring-sale"}`,
+			},
+		},
+		{
+			name: "post_api_shorten_with_taken_alias",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					s := makeMockStorage()
+					s.Set(context.Background(), "spring-sale", "https://ya.ru", time.Time{})
+					return s
+				}(),
+				httpMethod:  "POST",
+				requestURI:  "/api/shorten",
+				contentType: "application/json",
+				body:        `{"url":"https://practicum.yandex.ru","alias":"spring-sale"}`,
+			},
+			output: output{
+				statusCode:             409,
+				contentTypeHeaderValue: "application/json",
+				response:               `{"code":"alias_taken","message":"alias \"spring-sale\" is already taken","request_id":"` + testRequestID + `"}`,
+			},
+		},
+		{
+			name: "post_api_shorten_with_reserved_alias",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/api/shorten",
+				contentType:      "application/json",
+				body:             `{"url":"https://practicum.yandex.ru","alias":"ping"}`,
+			},
+			output: output{
+				statusCode:             400,
+				contentTypeHeaderValue: "application/json",
+			},
+		},
+		{
+			name: "post_api_shorten_with_invalid_alias",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/api/shorten",
+				contentType:      "application/json",
+				body:             `{"url":"https://practicum.yandex.ru","alias":"spring sale/"}`,
+			},
+			output: output{
+				statusCode:             400,
+				contentTypeHeaderValue: "application/json",
+			},
+		},
+		{
+			name: "success_post_api_shorten_with_ttl",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					ctrl := gomock.NewController(t)
+					m := mocks.NewMockStorage(ctrl)
+					m.EXPECT().Set(gomock.Any(), gomock.Any(), "https://practicum.yandex.ru/", cond(func(x any) bool {
+						expiresAt := x.(time.Time)
+						return expiresAt.After(time.Now().Add(59*time.Second)) && expiresAt.Before(time.Now().Add(61*time.Second))
+					})).Return(nil)
+					return m
+				}(),
+				httpMethod:  "POST",
+				requestURI:  "/api/shorten",
+				contentType: "application/json",
+				body:        `{"url":"https://practicum.yandex.ru","ttl_seconds":60}`,
+			},
+			output: output{
+				statusCode:             201,
+				contentTypeHeaderValue: "application/json",
+				response:               `{"result":"http://localhost:8080/`,
+			},
+		},
+		{
+			name: "post_api_shorten_with_expires_at_in_past",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/api/shorten",
+				contentType:      "application/json",
+				body:             `{"url":"https://practicum.yandex.ru","expires_at":"2000-01-01T00:00:00Z"}`,
+			},
+			output: output{
+				statusCode:             400,
+				contentTypeHeaderValue: "application/json",
+			},
+		},
+		{
+			name: "post_api_shorten_with_expires_at_and_ttl",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/api/shorten",
+				contentType:      "application/json",
+				body:             `{"url":"https://practicum.yandex.ru","expires_at":"2100-01-01T00:00:00Z","ttl_seconds":60}`,
+			},
+			output: output{
+				statusCode:             400,
+				contentTypeHeaderValue: "application/json",
+			},
+		},
 		{
 			name: "post_api_shorten_another_method",
 			input: input{
@@ -312,7 +679,7 @@ func TestServer(t *testing.T) {
 				body:             ``,
 			},
 			output: output{
-				statusCode:             500,
+				statusCode:             400,
 				contentTypeHeaderValue: "",
 				response:               ``,
 			},
@@ -421,6 +788,57 @@ func TestServer(t *testing.T) {
 				response:            "http://localhost:8080/",
 			},
 		},
+		{
+			name: "success_post_batch_with_expiry",
+			input: input{
+				preloadedStorage: func() handlers.Storage {
+					ctrl := gomock.NewController(t)
+					m := mocks.NewMockStorage(ctrl)
+					m.EXPECT().SetBatch(gomock.Any(), cond(func(x any) bool {
+						links := x.([]storage.Link)
+						return len(links) == 2 &&
+							links[0].ExpiresAt.Equal(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)) &&
+							links[1].ExpiresAt.IsZero()
+					})).Return(make([]error, 2), nil)
+					return m
+				}(),
+				httpMethod:  "POST",
+				requestURI:  "/api/shorten/batch",
+				contentType: "application/json",
+				body: `
+				[
+				  {
+					"correlation_id": "1",
+					"original_url": "https://ya.ru",
+					"expires_at": "2100-01-01T00:00:00Z"
+				  },
+				  {
+					"correlation_id": "2",
+					"original_url": "https://r0.ru"
+				  }
+				]
+				`,
+			},
+			output: output{
+				statusCode:             201,
+				contentTypeHeaderValue: "application/json",
+				response:               `[{"correlation_id":"1"`,
+			},
+		},
+		{
+			name: "post_batch_with_invalid_ttl",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "POST",
+				requestURI:       "/api/shorten/batch",
+				contentType:      "application/json",
+				body:             `[{"correlation_id": "1", "original_url": "https://ya.ru", "ttl_seconds": -1}]`,
+			},
+			output: output{
+				statusCode:             400,
+				contentTypeHeaderValue: "application/json",
+			},
+		},
 		{
 			name: "fail_post_batch_empty_body",
 			input: input{
@@ -431,7 +849,7 @@ func TestServer(t *testing.T) {
 				body:             ``,
 			},
 			output: output{
-				statusCode: 500,
+				statusCode: 400,
 			},
 		},
 		{
@@ -451,6 +869,7 @@ func TestServer(t *testing.T) {
 				}(),
 				httpMethod: "GET",
 				requestURI: "/api/user/urls",
+				userID:     "user",
 			},
 			output: output{
 				statusCode: 200,
@@ -469,11 +888,36 @@ func TestServer(t *testing.T) {
 				}(),
 				httpMethod: "GET",
 				requestURI: "/api/user/urls",
+				userID:     "user",
 			},
 			output: output{
 				statusCode: 204,
 			},
 		},
+		{
+			name: "unauthorized_user_urls",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "GET",
+				requestURI:       "/api/user/urls",
+			},
+			output: output{
+				statusCode: 401,
+			},
+		},
+		{
+			name: "unauthorized_delete_user_urls",
+			input: input{
+				preloadedStorage: makeMockStorage(),
+				httpMethod:       "DELETE",
+				requestURI:       "/api/user/urls",
+				contentType:      "application/json",
+				body:             `["0dd1981"]`,
+			},
+			output: output{
+				statusCode: 401,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -485,14 +929,18 @@ func TestServer(t *testing.T) {
 				panic(err)
 			}
 
-			m := middleware.MakeMiddleware(log)
+			m := makeMiddleware(t, nil, log)
 			h := handlers.MakeHandler(
 				tt.input.preloadedStorage,
 				"http://localhost:8080",
+				keygen.MakeHashGenerator(7),
+				makeDomainList(t, ""),
+				config.DeleteQueue{},
+				config.Clicks{},
 				log,
 			)
 
-			r := getRouter(h, m)
+			r := getRouter(h, m, nil)
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
@@ -514,6 +962,10 @@ func TestServer(t *testing.T) {
 			req.Header.Set("Content-Type", tt.input.contentType)
 			req.Header.Set("Accept-Encoding", tt.input.acceptEncoding)
 			req.Header.Set("Content-Encoding", tt.input.contentEncoding)
+			req.Header.Set(requestid.Header, testRequestID)
+			if tt.input.userID != "" {
+				req.Header.Set("Authorization", "Bearer "+makeToken(t, tt.input.userID))
+			}
 
 			//шлем запрос на сервер
 			client := ts.Client()
@@ -530,7 +982,8 @@ func TestServer(t *testing.T) {
 				assert.Equal(t, tt.output.contentTypeHeaderValue, resp.Header.Get("Content-Type"), "Ожидался Content-Type в ответе: %v, по факту: %v", tt.output.contentTypeHeaderValue, resp.Header.Get("Content-Type"))
 			}
 
-			if resp.StatusCode == http.StatusCreated {
+			if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusConflict ||
+				resp.StatusCode == http.StatusMultiStatus {
 				body := resp.Body
 				if strings.Contains(resp.Header.Get("Content-Encoding"), "gzip") {
 					gzipR, err := gzip.NewReader(body)
@@ -548,3 +1001,492 @@ func TestServer(t *testing.T) {
 		})
 	}
 }
+
+func TestShortenBatchStatuses(t *testing.T) {
+	type item struct {
+		CorrelationID string `json:"correlation_id"`
+		ShortURL      string `json:"short_url"`
+		Status        string `json:"status"`
+		Error         string `json:"error"`
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+		want       []item
+	}{
+		{
+			name: "mixed",
+			body: `[
+				{"correlation_id": "1", "original_url": "https://ya.ru"},
+				{"correlation_id": "2", "original_url": "https://r0.ru"},
+				{"correlation_id": "3", "original_url": "https://practicum.yandex.ru", "ttl_seconds": -1},
+				{"correlation_id": "4", "original_url": ""},
+				{"correlation_id": "5", "original_url": "javascript:alert(1)"}
+			]`,
+			statusCode: http.StatusMultiStatus,
+			want: []item{
+				{CorrelationID: "1", ShortURL: "http://localhost:8080/existing", Status: handlers.BatchStatusExisting},
+				{CorrelationID: "2", Status: handlers.BatchStatusCreated},
+				{CorrelationID: "3", Status: handlers.BatchStatusInvalid, Error: handlers.ErrTTLInvalid.Error()},
+				{CorrelationID: "4", Status: handlers.BatchStatusInvalid, Error: "original_url is required"},
+				{CorrelationID: "5", Status: handlers.BatchStatusInvalid, Error: `url scheme is not allowed: "javascript"`},
+			},
+		},
+		{
+			name: "all_existing",
+			body: `[
+				{"correlation_id": "1", "original_url": "https://ya.ru"},
+				{"correlation_id": "2", "original_url": "HTTPS://YA.RU:443"}
+			]`,
+			statusCode: http.StatusConflict,
+			want: []item{
+				{CorrelationID: "1", ShortURL: "http://localhost:8080/existing", Status: handlers.BatchStatusExisting},
+				{CorrelationID: "2", ShortURL: "http://localhost:8080/existing", Status: handlers.BatchStatusExisting},
+			},
+		},
+		{
+			name:       "all_invalid",
+			body:       `[{"correlation_id": "1", "original_url": ""}]`,
+			statusCode: http.StatusBadRequest,
+			want: []item{
+				{CorrelationID: "1", Status: handlers.BatchStatusInvalid, Error: "original_url is required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := logger.MakeNop()
+			require.NoError(t, err)
+
+			s := makeMockStorage()
+			s.m["existing"] = "https://ya.ru/"
+
+			m := makeMiddleware(t, nil, log)
+			h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""), config.DeleteQueue{}, config.Clicks{}, log)
+
+			ts := httptest.NewServer(getRouter(h, m, nil))
+			defer ts.Close()
+
+			resp, err := ts.Client().Post(ts.URL+"/api/shorten/batch", "application/json", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+
+			var got []item
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			require.Len(t, got, len(tt.want))
+			for i, want := range tt.want {
+				if want.Status == handlers.BatchStatusCreated {
+					assert.NotEmpty(t, got[i].ShortURL)
+					want.ShortURL = got[i].ShortURL
+				}
+				assert.Equal(t, want, got[i])
+			}
+		})
+	}
+}
+
+func TestRedirectRecordsClick(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	saved := make(chan []storage.Click, 1)
+	ctrl := gomock.NewController(t)
+	s := mocks.NewMockStorage(ctrl)
+	s.EXPECT().Get(gomock.Any(), "0dd1981").Return("https://practicum.yandex.ru/", nil)
+	s.EXPECT().SaveClicks(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, clicks []storage.Click) error {
+			saved <- clicks
+			return nil
+		})
+
+	m := makeMiddleware(t, nil, log)
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""), config.DeleteQueue{}, config.Clicks{}, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.RecordClicks(ctx)
+
+	ts := httptest.NewServer(getRouter(h, m, nil))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/0dd1981", nil)
+	require.NoError(t, err)
+	req.Header.Set("Referer", "https://ya.ru/")
+	req.Header.Set("User-Agent", "test-agent")
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	select {
+	case clicks := <-saved:
+		require.Len(t, clicks, 1)
+		assert.Equal(t, "0dd1981", clicks[0].ShortURL)
+		assert.Equal(t, "https://ya.ru/", clicks[0].Referrer)
+		assert.Equal(t, "test-agent", clicks[0].UserAgent)
+		assert.Len(t, clicks[0].IPHash, 64)
+		assert.NotContains(t, clicks[0].IPHash, "127.0.0.1")
+	case <-time.After(3 * time.Second):
+		t.Fatal("клик не был сохранён")
+	}
+}
+
+func TestAnonymousRedirectsSkipIssueLimit(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	m, err := middleware.MakeMiddleware(config.Config{
+		Auth: config.Auth{
+			JWTKeys: []config.JWTKey{{ID: "test", Secret: "test-secret"}},
+		},
+		RateLimit: config.RateLimit{
+			IssueLimit: config.RateBudget{Limit: 30, Period: time.Minute},
+		},
+	}, nil, log)
+	require.NoError(t, err)
+
+	s := makeMockStorage()
+	s.m["0dd1981"] = "https://practicum.yandex.ru/"
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""), config.DeleteQueue{}, config.Clicks{}, log)
+
+	ts := httptest.NewServer(getRouter(h, m, nil))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for i := 0; i < 40; i++ {
+		resp, err := client.Get(ts.URL + "/0dd1981")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "переход %d", i+1)
+		assert.Empty(t, resp.Header.Get(middleware.IssuedTokenHeader))
+		assert.Empty(t, resp.Cookies())
+	}
+
+	// Бюджет выдачи токенов остался нетронутым: новый пользователь по-прежнему может создать ссылку.
+	resp, err := client.Post(ts.URL+"/", "text/plain", strings.NewReader("https://ya.ru/"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get(middleware.IssuedTokenHeader))
+}
+
+func TestGetURLStats(t *testing.T) {
+	stats := storage.ClickStats{
+		TotalClicks:    3,
+		UniqueVisitors: 2,
+		ClicksPerDay:   []storage.CountItem{{Value: "2026-10-01", Count: 3}},
+		TopReferrers:   []storage.CountItem{{Value: "https://ya.ru/", Count: 2}},
+		TopUserAgents:  []storage.CountItem{{Value: "curl/8.0", Count: 3}},
+	}
+
+	tests := []struct {
+		name       string
+		requestURI string
+		setup      func(m *mocks.MockStorage)
+		statusCode int
+		response   string
+	}{
+		{
+			name:       "success",
+			requestURI: "/api/user/urls/0dd1981/stats?from=2026-10-01&to=2026-10-02",
+			setup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetClickStats(gomock.Any(), "0dd1981",
+					time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+					time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC),
+				).Return(stats, nil)
+			},
+			statusCode: 200,
+			response: `{"short_url":"http://localhost:8080/0dd1981","total_clicks":3,"unique_visitors":2,` +
+				`"clicks_per_day":[{"date":"2026-10-01","clicks":3}],` +
+				`"top_referrers":[{"referrer":"https://ya.ru/","clicks":2}],` +
+				`"top_user_agents":[{"user_agent":"curl/8.0","clicks":3}]}`,
+		},
+		{
+			name:       "empty_stats",
+			requestURI: "/api/user/urls/0dd1981/stats",
+			setup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetClickStats(gomock.Any(), "0dd1981", time.Time{}, time.Time{}).
+					Return(storage.ClickStats{}, nil)
+			},
+			statusCode: 200,
+			response: `{"short_url":"http://localhost:8080/0dd1981","total_clicks":0,"unique_visitors":0,` +
+				`"clicks_per_day":[],"top_referrers":[],"top_user_agents":[]}`,
+		},
+		{
+			name:       "not_owned",
+			requestURI: "/api/user/urls/0dd1981/stats",
+			setup: func(m *mocks.MockStorage) {
+				m.EXPECT().GetClickStats(gomock.Any(), "0dd1981", gomock.Any(), gomock.Any()).
+					Return(storage.ClickStats{}, storage.ErrNotOwned)
+			},
+			statusCode: 404,
+			response:   `{"code":"not_found","message":"url not found","request_id":"` + testRequestID + `"}`,
+		},
+		{
+			name:       "invalid_from",
+			requestURI: "/api/user/urls/0dd1981/stats?from=yesterday",
+			setup:      func(m *mocks.MockStorage) {},
+			statusCode: 400,
+		},
+		{
+			name:       "from_after_to",
+			requestURI: "/api/user/urls/0dd1981/stats?from=2026-10-02&to=2026-10-01",
+			setup:      func(m *mocks.MockStorage) {},
+			statusCode: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := logger.MakeNop()
+			require.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			s := mocks.NewMockStorage(ctrl)
+			tt.setup(s)
+
+			m := makeMiddleware(t, nil, log)
+			h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""), config.DeleteQueue{}, config.Clicks{}, log)
+
+			ts := httptest.NewServer(getRouter(h, m, nil))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.requestURI, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+makeToken(t, "user"))
+			req.Header.Set(requestid.Header, testRequestID)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			if tt.response != "" {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.JSONEq(t, tt.response, string(body))
+			}
+		})
+	}
+}
+
+func TestDomainList(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	s := makeMockStorage()
+	s.m["phish"] = "https://login.evil.example/"
+
+	m := makeMiddleware(t, nil, log)
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7),
+		makeDomainList(t, "deny evil.example\n"), config.DeleteQueue{}, config.Clicks{}, log)
+
+	ts := httptest.NewServer(getRouter(h, m, nil))
+	defer ts.Close()
+
+	t.Run("shorten_blocked", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten",
+			strings.NewReader(`{"url":"https://evil.example/login"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(requestid.Header, testRequestID)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, testRequestID, resp.Header.Get(requestid.Header))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"code":"domain_not_allowed","message":"domain is blocked: evil.example","request_id":"`+testRequestID+`"}`, string(body))
+	})
+
+	t.Run("redirect_blocked", func(t *testing.T) {
+		client := ts.Client()
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		resp, err := client.Get(ts.URL + "/phish")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+		assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	s := storage.MakeMemoryStorage()
+	m := makeMiddleware(t, s, log)
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""), config.DeleteQueue{}, config.Clicks{}, log)
+
+	ts := httptest.NewServer(getRouter(h, m, nil))
+	defer ts.Close()
+
+	token := makeToken(t, "user-1")
+	do := func(method, path, body string, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header = header
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			resp.Body.Close()
+		})
+		return resp
+	}
+	bearer := http.Header{"Authorization": {"Bearer " + token}}
+
+	resp := do(http.MethodPost, "/api/user/keys", `{"name":""}`, bearer.Clone())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/api/user/keys", `{"name":"ci"}`, http.Header{})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/api/user/keys", `{"name":"ci"}`, bearer.Clone())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created handlers.APIKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, "ci", created.Name)
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+
+	resp = do(http.MethodGet, "/api/user/keys", "", bearer.Clone())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var listed []handlers.APIKey
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0].ID)
+	assert.Empty(t, listed[0].Key, "ключ показывается только при создании")
+
+	withKey := http.Header{middleware.APIKeyHeader: {created.Key}}
+	resp = do(http.MethodGet, "/api/user/keys", "", withKey.Clone())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "ключ API определяет того же пользователя")
+	assert.Empty(t, resp.Header.Get(middleware.IssuedTokenHeader))
+	assert.Empty(t, resp.Cookies())
+
+	resp = do(http.MethodGet, "/api/user/keys", "", http.Header{"Authorization": {"Bearer " + makeToken(t, "user-2")}})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(http.MethodDelete, "/api/user/keys/"+created.ID, "",
+		http.Header{"Authorization": {"Bearer " + makeToken(t, "user-2")}})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "чужой ключ отозвать нельзя")
+
+	resp = do(http.MethodDelete, "/api/user/keys/"+created.ID, "", bearer.Clone())
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/api/user/keys", "", withKey.Clone())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "отозванный ключ не принимается")
+
+	resp = do(http.MethodDelete, "/api/user/keys/"+created.ID, "", bearer.Clone())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDeleteQueue(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	deleteURLs := func(t *testing.T, url, userID, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodDelete, url+"/api/user/urls", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+makeToken(t, userID))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("coalesces_users", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		s := mocks.NewMockStorage(ctrl)
+		s.EXPECT().DeleteBatch(gomock.Any(), []storage.Deletion{
+			{UserID: "user-1", Keys: []string{"a", "b"}},
+			{UserID: "user-2", Keys: []string{"c"}},
+		}).Return(nil)
+
+		h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""),
+			config.DeleteQueue{Workers: 1, QueueSize: 10, BatchSize: 3, FlushInterval: time.Hour}, config.Clicks{}, log)
+		ts := httptest.NewServer(getRouter(h, makeMiddleware(t, nil, log), nil))
+		defer ts.Close()
+
+		// Запросы копятся в очереди, пока обработчик не запущен, и уходят в хранилище одной пачкой.
+		assert.Equal(t, http.StatusAccepted, deleteURLs(t, ts.URL, "user-1", `["a","b"]`).StatusCode)
+		assert.Equal(t, http.StatusAccepted, deleteURLs(t, ts.URL, "user-2", `["c"]`).StatusCode)
+		assert.Equal(t, 2, h.DeleteQueueStats().Depth)
+
+		h.Start(context.Background(), time.Hour)
+		require.NoError(t, h.Close(context.Background()))
+		assert.EqualValues(t, 3, h.DeleteQueueStats().Deleted)
+	})
+
+	t.Run("rejects_when_full", func(t *testing.T) {
+		h := handlers.MakeHandler(makeMockStorage(), "http://localhost:8080", keygen.MakeHashGenerator(7),
+			makeDomainList(t, ""), config.DeleteQueue{Workers: 1, QueueSize: 1, BatchSize: 10, FlushInterval: time.Hour}, config.Clicks{}, log)
+		ts := httptest.NewServer(getRouter(h, makeMiddleware(t, nil, log), nil))
+		defer ts.Close()
+
+		assert.Equal(t, http.StatusAccepted, deleteURLs(t, ts.URL, "user-1", `["a"]`).StatusCode)
+		resp := deleteURLs(t, ts.URL, "user-1", `["b"]`)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+		stats := h.DeleteQueueStats()
+		assert.Equal(t, handlers.DeleteQueueStats{Depth: 1, Capacity: 1, Rejected: 1}, stats)
+	})
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	s := makeMockStorage()
+	s.m["0dd1981"] = "https://ya.ru/"
+
+	mx := metrics.MakeMetrics()
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""),
+		config.DeleteQueue{}, config.Clicks{}, log)
+	ts := httptest.NewServer(getRouter(h, makeMiddleware(t, nil, log), mx))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(ts.URL + "/0dd1981")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	resp, err = client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(`{"url":"https://r0.ru","alias":"metrics"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "алиас metrics занят маршрутом метрик")
+
+	resp, err = client.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `shortener_http_requests_total{method="GET",route="/{shortUrl}",status="307"} 1`)
+}
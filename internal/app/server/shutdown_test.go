@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/handlers"
+	"github.com/eduardtungatarov/shortener/internal/app/keygen"
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestServer запускает serve с файловым хранилищем и маршрутом /slow,
+// который отвечает только после закрытия release.
+func startTestServer(t *testing.T, ctx context.Context, filename string, timeout time.Duration,
+	release <-chan struct{}) (storage.Storage, *handlers.Handler, string, <-chan error) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	s, err := storage.MakeStorage(config.Config{FileStoragePath: filename})
+	require.NoError(t, err)
+	require.NoError(t, s.Load(context.Background()))
+
+	m := makeMiddleware(t, nil, log)
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""), config.DeleteQueue{}, config.Clicks{}, log)
+	h.Start(ctx, time.Hour)
+
+	router := getRouter(h, m, nil)
+	router.Get("/slow", func(res http.ResponseWriter, req *http.Request) {
+		<-release
+		res.WriteHeader(http.StatusOK)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, &http.Server{Handler: router}, ln, timeout, h)
+	}()
+	return s, h, "http://" + ln.Addr().String(), done
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+
+	s, _, url, done := startTestServer(t, ctx, filename, 5*time.Second, release)
+	userCtx := context.WithValue(context.Background(), config.UserIDKeyName, "owner")
+	require.NoError(t, s.Set(userCtx, "abc", "https://ya.ru/", time.Time{}))
+
+	req, err := http.NewRequest(http.MethodDelete, url+"/api/user/urls", strings.NewReader(`["abc"]`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+makeToken(t, "owner"))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	slow := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	// Даём медленному запросу дойти до обработчика.
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		t.Fatalf("сервер остановился, не дождавшись запроса: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = http.Get(url + "/ping")
+	assert.Error(t, err, "новые соединения после начала остановки не принимаются")
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-slow, "начатый запрос должен завершиться")
+	require.NoError(t, <-done)
+	require.NoError(t, s.Close())
+
+	// Удаление из очереди сохранено до закрытия хранилища.
+	s, err = storage.MakeStorage(config.Config{FileStoragePath: filename})
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Load(context.Background()))
+	_, err = s.Get(context.Background(), "abc")
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+}
+
+func TestGracefulShutdownTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+
+	s, h, url, done := startTestServer(t, ctx, filepath.Join(t.TempDir(), "short-url-db.json"), 100*time.Millisecond, release)
+	defer s.Close()
+
+	go http.Get(url + "/slow")
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(3 * time.Second):
+		t.Fatal("сервер не остановился за отведённое время")
+	}
+
+	// Очереди уже закрыты, повторный Close только дожидается обработчиков.
+	assert.NoError(t, h.Close(context.Background()))
+}
+
+func TestListenAddressInUse(t *testing.T) {
+	ln, err := Listen(config.Config{ServerHostPort: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	_, err = Listen(config.Config{ServerHostPort: ln.Addr().String()})
+	assert.Error(t, err)
+}
+
+func TestServeFailure(t *testing.T) {
+	log, err := logger.MakeNop()
+	require.NoError(t, err)
+
+	s, err := storage.MakeStorage(config.Config{FileStoragePath: filepath.Join(t.TempDir(), "short-url-db.json")})
+	require.NoError(t, err)
+	defer s.Close()
+
+	h := handlers.MakeHandler(s, "http://localhost:8080", keygen.MakeHashGenerator(7), makeDomainList(t, ""), config.DeleteQueue{}, config.Clicks{}, log)
+	h.Start(context.Background(), time.Hour)
+
+	// Закрытый слушатель: Serve сразу возвращает ошибку, сервер всё равно останавливается корректно.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+
+	err = serve(context.Background(), &http.Server{Handler: getRouter(h, makeMiddleware(t, nil, log), nil)}, ln, time.Second, h)
+	assert.ErrorIs(t, err, ErrServe)
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тесты имеют смысл под детектором гонок: go test -race ./internal/app/storage/
+func TestConcurrentAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage func(t *testing.T) Storage
+	}{
+		{
+			name: "memory",
+			storage: func(t *testing.T) Storage {
+				return MakeMemoryStorage()
+			},
+		},
+		{
+			name: "file",
+			storage: func(t *testing.T) Storage {
+				s, err := MakeFileStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+				require.NoError(t, err)
+				require.NoError(t, s.Load(context.Background()))
+				return s
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.storage(t)
+			defer s.Close()
+			hammer(t, s)
+		})
+	}
+}
+
+func hammer(t *testing.T, s Storage) {
+	const (
+		users        = 8
+		linksPerUser = 50
+	)
+
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		userID := fmt.Sprintf("user-%d", u)
+		ctx := userCtx(userID)
+
+		// Писатель: одиночные и пакетные вставки.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < linksPerUser; i++ {
+				key := fmt.Sprintf("%s-%d", userID, i)
+				url := "https://example.com/" + key
+				if i%2 == 0 {
+					assert.NoError(t, s.Set(ctx, key, url, time.Time{}))
+				} else {
+					errs, err := s.SetBatch(ctx, []Link{{ShortURL: key, OriginalURL: url}})
+					assert.NoError(t, err)
+					assert.NoError(t, errors.Join(errs...))
+				}
+			}
+		}()
+
+		// Читатель: одиночные ссылки, списки пользователя и статистика.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < linksPerUser; i++ {
+				key := fmt.Sprintf("%s-%d", userID, i)
+				_, err := s.Get(ctx, key)
+				assert.NotErrorIs(t, err, ErrExpired)
+				_, err = s.GetByUserID(ctx)
+				assert.NoError(t, err)
+				_, err = s.GetClickStats(ctx, key, time.Time{}, time.Time{})
+				if err != nil && !errors.Is(err, ErrNotOwned) {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+
+		// Удаление чётных ссылок и клики по ним.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < linksPerUser; i += 2 {
+				key := fmt.Sprintf("%s-%d", userID, i)
+				assert.NoError(t, s.SaveClicks(ctx, []Click{{ShortURL: key, ClickedAt: time.Now(), IPHash: userID}}))
+				assert.NoError(t, s.DeleteBatch(ctx, []Deletion{{UserID: userID, Keys: []string{key}}}))
+			}
+		}()
+	}
+
+	// Чистка просроченных ссылок параллельно со всем остальным.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx := userCtx("sweeper")
+		for i := 0; i < linksPerUser; i++ {
+			key := fmt.Sprintf("expiring-%d", i)
+			assert.NoError(t, s.Set(ctx, key, "https://example.com/"+key, time.Now().Add(-time.Millisecond)))
+			_, err := s.DeleteExpired(ctx)
+			assert.NoError(t, err)
+		}
+	}()
+
+	wg.Wait()
+
+	// После завершения всех горутин каждая ссылка сохранена ровно один раз.
+	for u := 0; u < users; u++ {
+		userID := fmt.Sprintf("user-%d", u)
+		urls, err := s.GetByUserID(userCtx(userID))
+		require.NoError(t, err)
+		assert.Len(t, urls, linksPerUser)
+
+		for i := 1; i < linksPerUser; i += 2 {
+			key := fmt.Sprintf("%s-%d", userID, i)
+			v, err := s.Get(context.Background(), key)
+			require.NoError(t, err)
+			assert.Equal(t, "https://example.com/"+key, v)
+		}
+	}
+}
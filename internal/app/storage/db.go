@@ -6,166 +6,224 @@ import (
 	"errors"
 	"fmt"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/migrations"
 	"github.com/google/uuid"
-	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgconn"
-	"strings"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"time"
 )
 
 var ErrConflict = errors.New("data conflict")
+var ErrNotFound = errors.New("url not found")
 var ErrDeleted = errors.New("url deleted")
+var ErrKeyCollision = errors.New("key is taken by another url")
+var ErrAliasTaken = errors.New("alias is already taken")
+var ErrExpired = errors.New("url expired")
+var ErrNotOwned = errors.New("url is not owned by user")
+
+// KeyError привязывает ошибку хранилища к ключу: для ErrConflict это ключ,
+// под которым url уже сохранён, для ErrKeyCollision — занятый ключ.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("key %s: %v", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// pgxPool — часть *pgxpool.Pool, которой пользуется dbStorage; её же реализует pgxmock в тестах.
+type pgxPool interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Ping(ctx context.Context) error
+	Close()
+}
 
 type dbStorage struct {
-	sqlDB   *sql.DB
-	timeout time.Duration
+	pool       pgxPool
+	connConfig *pgx.ConnConfig
+	timeout    time.Duration
 }
 
 func MakeDBStorage(cfg config.Database) (*dbStorage, error) {
-	sqlDB, err := sql.Open("pgx", cfg.DSN)
+	poolConfig, err := pgxpool.ParseConfig(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	// Без установленного провайдера трассировки спаны запросов ничего не стоят.
+	poolConfig.ConnConfig.Tracer = multitracer.New(makeQueryTracer(), queryLogger{})
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	return &dbStorage{
-		sqlDB:   sqlDB,
-		timeout: cfg.Timeout,
+		pool:       pool,
+		connConfig: poolConfig.ConnConfig,
+		timeout:    cfg.Timeout,
 	}, nil
 }
 
+// Load применяет неприменённые миграции схемы.
+// Мигратор работает через database/sql, поэтому на время миграций открываем отдельное соединение.
 func (s *dbStorage) Load(ctx context.Context) error {
-	createTableSQL := `
-        CREATE TABLE IF NOT EXISTS urls (
-            uuid UUID PRIMARY KEY,
-            short_url VARCHAR(255) NOT NULL UNIQUE,
-            original_url TEXT NOT NULL
-        );
-    `
-	createShortURLIndexSQL := `
-        CREATE INDEX IF NOT EXISTS idx_short_url ON urls (short_url);
-    `
-
-	addUserUUIDColumn := `
-    DO $$
-    BEGIN
-        IF NOT EXISTS (
-            SELECT 1 
-            FROM information_schema.columns 
-            WHERE table_name='urls' 
-            AND column_name='user_uuid'
-        ) THEN
-            ALTER TABLE urls ADD COLUMN user_uuid UUID;
-        END IF;
-    END $$;`
-
-	createUserUUIDIndex := `
-		CREATE INDEX IF NOT EXISTS idx_user_uuid ON urls (user_uuid);
-	`
-
-	addDeletedFlagColumn := `
-    DO $$
-    BEGIN
-        IF NOT EXISTS (
-            SELECT 1 
-            FROM information_schema.columns 
-            WHERE table_name='urls' 
-            AND column_name='deleted_flag'
-        ) THEN
-            ALTER TABLE urls ADD COLUMN deleted_flag integer default 0;
-        END IF;
-    END $$;`
-
-	_, err := s.sqlDB.ExecContext(ctx, createTableSQL)
-	if err != nil {
-		return err
-	}
+	sqlDB := stdlib.OpenDB(*s.connConfig)
+	defer sqlDB.Close()
 
-	_, err = s.sqlDB.ExecContext(ctx, createShortURLIndexSQL)
+	m, err := migrations.MakeMigrator(sqlDB)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.sqlDB.ExecContext(ctx, addUserUUIDColumn)
+	_, err = m.Up(ctx)
+	return err
+}
+
+func (s *dbStorage) Set(ctx context.Context, key, value string, expiresAt time.Time) error {
+	errs, err := s.SetBatch(ctx, []Link{{ShortURL: key, OriginalURL: value, ExpiresAt: expiresAt}})
 	if err != nil {
 		return err
 	}
+	return errs[0]
+}
 
-	_, err = s.sqlDB.ExecContext(ctx, createUserUUIDIndex)
+func (s *dbStorage) SetAlias(ctx context.Context, alias, value string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	userID, err := getUserIDOrPanic(ctx)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.sqlDB.ExecContext(ctx, addDeletedFlagColumn)
+	tag, err := s.pool.Exec(ctx, `INSERT INTO urls (uuid, short_url, original_url, user_uuid, expires_at, is_alias)
+		VALUES ($1, $2, $3, $4, $5, true)
+		ON CONFLICT (short_url) DO NOTHING
+	`, uuid.NewString(), alias, value, userID, nullTime(expiresAt))
 	if err != nil {
 		return err
 	}
 
+	if tag.RowsAffected() == 0 {
+		return &KeyError{Key: alias, Err: ErrAliasTaken}
+	}
 	return nil
 }
 
-func (s *dbStorage) Set(ctx context.Context, key, value string) error {
+// purgeExpiredLinksSQL удаляет просроченные ссылки на url пачки вместе с их кликами,
+// чтобы такой url можно было сократить заново, не дожидаясь очистки.
+const purgeExpiredLinksSQL = `WITH expired AS (
+		DELETE FROM urls WHERE original_url = ANY($1) AND expires_at <= now() RETURNING short_url
+	)
+	DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM expired)`
+
+// insertLinkSQL вставляет ссылку, только если у url ещё нет основной ссылки и ключ свободен.
+// Условие совпадает с условием уникального индекса idx_original_url_unique, который отсекает
+// одновременную вставку одного url: удалённые ссылки и псевдонимы основной ссылкой не считаются.
+const insertLinkSQL = `INSERT INTO urls (uuid, short_url, original_url, user_uuid, expires_at)
+	SELECT $1, $2, $3, $4, $5
+	WHERE NOT EXISTS (SELECT 1 FROM urls WHERE original_url = $3 AND deleted_flag = 0 AND NOT is_alias)
+	ON CONFLICT DO NOTHING`
+
+// selectKeySQL находит основной ключ, под которым url сохранён.
+const selectKeySQL = `SELECT short_url FROM urls WHERE original_url = $1 AND deleted_flag = 0 AND NOT is_alias LIMIT 1`
+
+// SetBatch отправляет всю пачку одним pgx.Batch: очистку просроченных ссылок на её url,
+// затем для каждой ссылки вставку и следом поиск ключа её url. Если вставка ничего не изменила,
+// по найденному ключу понятно, сохранён ли url раньше (ErrConflict) или ключ занят другим url
+// (ErrKeyCollision).
+func (s *dbStorage) SetBatch(ctx context.Context, links []Link) ([]error, error) {
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
 	userID, err := getUserIDOrPanic(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = s.sqlDB.ExecContext(ctx, `INSERT INTO urls (uuid, short_url, original_url, user_uuid)
-		VALUES ($1, $2, $3, $4)
-	`, uuid.NewString(), key, value, userID)
-
-	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
-			err = ErrConflict
-		}
+	originalURLs := make([]string, len(links))
+	for i, l := range links {
+		originalURLs[i] = l.OriginalURL
 	}
 
-	return err
-}
-
-func (s *dbStorage) SetBatch(ctx context.Context, keyValues map[string]string) error {
-	tx, err := s.sqlDB.Begin()
-	if err != nil {
-		return err
+	batch := &pgx.Batch{}
+	batch.Queue(purgeExpiredLinksSQL, originalURLs)
+	for _, l := range links {
+		batch.Queue(insertLinkSQL, uuid.NewString(), l.ShortURL, l.OriginalURL, userID, nullTime(l.ExpiresAt))
+		batch.Queue(selectKeySQL, l.OriginalURL)
 	}
 
-	userID, err := getUserIDOrPanic(ctx)
+	br := s.pool.SendBatch(ctx, batch)
+	defer br.Close()
+
+	_, err = br.Exec()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for key, value := range keyValues {
-		_, err := tx.ExecContext(ctx,
-			"INSERT INTO urls (uuid, short_url, original_url, user_uuid)"+
-				" VALUES($1, $2, $3, $4)", uuid.NewString(), key, value, userID)
+	errs := make([]error, len(links))
+	for i, l := range links {
+		tag, err := br.Exec()
 		if err != nil {
-			tx.Rollback()
-			return err
+			return nil, err
+		}
+
+		var existingKey string
+		err = br.QueryRow().Scan(&existingKey)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+
+		switch {
+		case tag.RowsAffected() > 0:
+		case existingKey != "":
+			errs[i] = &KeyError{Key: existingKey, Err: ErrConflict}
+		default:
+			errs[i] = &KeyError{Key: l.ShortURL, Err: ErrKeyCollision}
 		}
 	}
-	return tx.Commit()
+
+	return errs, br.Close()
 }
 
 func (s *dbStorage) Get(ctx context.Context, key string) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	row := s.sqlDB.QueryRowContext(ctx, `SELECT original_url, deleted_flag FROM urls WHERE short_url = $1`, key)
+	row := s.pool.QueryRow(ctx, `SELECT original_url, deleted_flag, expires_at FROM urls WHERE short_url = $1`, key)
 
 	var originalURL string
-	var deletedFlag bool
-	err := row.Scan(&originalURL, &deletedFlag)
+	var deletedFlag int
+	var expiresAt *time.Time
+	err := row.Scan(&originalURL, &deletedFlag, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
 	if err != nil {
 		return "", err
 	}
 
-	if deletedFlag {
+	if deletedFlag != 0 {
 		return "", ErrDeleted
 	}
 
+	if expiresAt != nil && isExpired(*expiresAt) {
+		return "", ErrExpired
+	}
+
 	return originalURL, nil
 }
 
@@ -178,7 +236,7 @@ func (s *dbStorage) GetByUserID(ctx context.Context) ([]map[string]string, error
 		return nil, err
 	}
 
-	rows, err := s.sqlDB.QueryContext(ctx, `SELECT original_url, short_url FROM urls WHERE user_uuid = $1`, userID)
+	rows, err := s.pool.Query(ctx, `SELECT original_url, short_url FROM urls WHERE user_uuid = $1`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -205,37 +263,262 @@ func (s *dbStorage) GetByUserID(ctx context.Context) ([]map[string]string, error
 	return urls, nil
 }
 
-func (s *dbStorage) DeleteBatch(ctx context.Context, keys []string, userID string) error {
-	placeholders := make([]string, len(keys))
-	for i := range keys {
-		placeholders[i] = fmt.Sprintf("$%d", i+1)
+// DeleteBatch удаляет ссылки всех пользователей одним запросом: пары (ключ, владелец)
+// передаются двумя массивами и разворачиваются через unnest.
+func (s *dbStorage) DeleteBatch(ctx context.Context, deletions []Deletion) error {
+	var keys, userIDs []string
+	for _, d := range deletions {
+		for _, key := range d.Keys {
+			keys = append(keys, key)
+			userIDs = append(userIDs, d.UserID)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `UPDATE urls SET deleted_flag = 1
+		FROM unnest($1::varchar[], $2::uuid[]) AS d(short_url, user_uuid)
+		WHERE urls.short_url = d.short_url AND urls.user_uuid = d.user_uuid`,
+		keys, userIDs)
+	return err
+}
+
+func (s *dbStorage) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM clicks WHERE short_url IN (
+		SELECT short_url FROM urls WHERE expires_at <= now()
+	)`)
+	if err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM urls WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// SaveClicks загружает клики через COPY.
+func (s *dbStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.pool.CopyFrom(ctx,
+		pgx.Identifier{"clicks"},
+		[]string{"short_url", "clicked_at", "referrer", "user_agent", "ip_hash"},
+		pgx.CopyFromSlice(len(clicks), func(i int) ([]any, error) {
+			c := clicks[i]
+			return []any{c.ShortURL, c.ClickedAt, c.Referrer, c.UserAgent, c.IPHash}, nil
+		}),
+	)
+	return err
+}
+
+func (s *dbStorage) GetClickStats(ctx context.Context, key string, from, to time.Time) (ClickStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var stats ClickStats
+
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	var owned bool
+	err = s.pool.QueryRow(ctx, `SELECT true FROM urls WHERE short_url = $1 AND user_uuid = $2`, key, userID).
+		Scan(&owned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return stats, ErrNotOwned
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	const filter = ` FROM clicks WHERE short_url = $1
+		AND ($2::timestamptz IS NULL OR clicked_at >= $2)
+		AND ($3::timestamptz IS NULL OR clicked_at < $3)`
+	args := []interface{}{key, nullTime(from), nullTime(to)}
+
+	err = s.pool.QueryRow(ctx, `SELECT count(*), count(DISTINCT ip_hash)`+filter, args...).
+		Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.ClicksPerDay, err = s.queryCountItems(ctx,
+		`SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*)`+filter+
+			` GROUP BY day ORDER BY day`, args...)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.TopReferrers, err = s.queryCountItems(ctx,
+		`SELECT referrer, count(*) AS n`+filter+` AND referrer <> ''`+
+			fmt.Sprintf(` GROUP BY referrer ORDER BY n DESC, referrer LIMIT %d`, topLimit), args...)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.TopUserAgents, err = s.queryCountItems(ctx,
+		`SELECT user_agent, count(*) AS n`+filter+` AND user_agent <> ''`+
+			fmt.Sprintf(` GROUP BY user_agent ORDER BY n DESC, user_agent LIMIT %d`, topLimit), args...)
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+func (s *dbStorage) queryCountItems(ctx context.Context, query string, args ...interface{}) ([]CountItem, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []CountItem
+	for rows.Next() {
+		var v CountItem
+		err = rows.Scan(&v.Value, &v.Count)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
 	}
 
-	query := fmt.Sprintf("UPDATE urls SET deleted_flag = 1 WHERE short_url IN (%s) AND user_uuid = $%d;",
-		strings.Join(placeholders, ", "), len(keys)+1)
+	return items, rows.Err()
+}
 
-	args := make([]interface{}, len(keys)+1)
-	for i, key := range keys {
-		args[i] = key
+func (s *dbStorage) SaveAPIKey(ctx context.Context, key APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return err
 	}
-	args[len(keys)] = userID
 
-	_, err := s.sqlDB.ExecContext(ctx, query, args...)
+	_, err = s.pool.Exec(ctx, `INSERT INTO api_keys (id, user_uuid, name, key_hash, prefix, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		key.ID, userID, key.Name, key.Hash, key.Prefix, key.CreatedAt)
+	return err
+}
+
+func (s *dbStorage) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT id, name, key_hash, prefix, created_at FROM api_keys
+		WHERE user_uuid = $1 AND revoked_at IS NULL ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		v := APIKey{UserID: userID}
+		err = rows.Scan(&v.ID, &v.Name, &v.Hash, &v.Prefix, &v.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, v)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey не удаляет ключ, а проставляет revoked_at, чтобы отзыв оставался в истории.
+func (s *dbStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	userID, err := getUserIDOrPanic(ctx)
 	if err != nil {
 		return err
 	}
+
+	// Неверный uuid в пути — такого ключа точно нет, не отправляем запрос, который упадёт на приведении типа.
+	if uuid.Validate(id) != nil {
+		return ErrNotFound
+	}
+
+	tag, err := s.pool.Exec(ctx, `UPDATE api_keys SET revoked_at = now()
+		WHERE id = $1 AND user_uuid = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
+func (s *dbStorage) GetUserIDByAPIKey(ctx context.Context, hash string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var userID string
+	err := s.pool.QueryRow(ctx, `SELECT user_uuid FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL`, hash).
+		Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
 func (s *dbStorage) Ping(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
-	if err := s.sqlDB.PingContext(ctx); err != nil {
+	if err := s.pool.Ping(ctx); err != nil {
 		return err
 	}
 	return nil
 }
 
+// PoolStat возвращает статистику пула соединений или nil, если пул подменён в тестах.
+func (s *dbStorage) PoolStat() *pgxpool.Stat {
+	if p, ok := s.pool.(*pgxpool.Pool); ok {
+		return p.Stat()
+	}
+	return nil
+}
+
 func (s *dbStorage) Close() error {
-	return s.sqlDB.Close()
+	s.pool.Close()
+	return nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{
+		Time:  t,
+		Valid: !t.IsZero(),
+	}
 }
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeMockDBStorage(t *testing.T) (*dbStorage, pgxmock.PgxPoolIface) {
+	pool, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	return &dbStorage{
+		pool:    pool,
+		timeout: time.Second,
+	}, pool
+}
+
+func TestDBStorageGet(t *testing.T) {
+	const query = `SELECT original_url, deleted_flag, expires_at FROM urls WHERE short_url = \$1`
+	columns := []string{"original_url", "deleted_flag", "expires_at"}
+	expiredAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		expect  func(mock pgxmock.PgxPoolIface)
+		want    string
+		wantErr error
+	}{
+		{
+			name: "found",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectQuery(query).WithArgs("abc").
+					WillReturnRows(pgxmock.NewRows(columns).AddRow("https://ya.ru", 0, nil))
+			},
+			want: "https://ya.ru",
+		},
+		{
+			name: "not_found",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectQuery(query).WithArgs("abc").
+					WillReturnRows(pgxmock.NewRows(columns))
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "deleted",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectQuery(query).WithArgs("abc").
+					WillReturnRows(pgxmock.NewRows(columns).AddRow("https://ya.ru", 1, nil))
+			},
+			wantErr: ErrDeleted,
+		},
+		{
+			name: "expired",
+			expect: func(mock pgxmock.PgxPoolIface) {
+				mock.ExpectQuery(query).WithArgs("abc").
+					WillReturnRows(pgxmock.NewRows(columns).AddRow("https://ya.ru", 0, &expiredAt))
+			},
+			wantErr: ErrExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := makeMockDBStorage(t)
+			tt.expect(mock)
+
+			v, err := s.Get(context.Background(), "abc")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, v)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("backend_failure", func(t *testing.T) {
+		s, mock := makeMockDBStorage(t)
+		failure := errors.New("connection refused")
+		mock.ExpectQuery(query).WithArgs("abc").WillReturnError(failure)
+
+		_, err := s.Get(context.Background(), "abc")
+		assert.ErrorIs(t, err, failure)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestDBStorageSetBatch(t *testing.T) {
+	const insertQuery = `INSERT INTO urls`
+	const selectQuery = `SELECT short_url FROM urls WHERE original_url = \$1 AND deleted_flag = 0 AND NOT is_alias`
+	const purgeQuery = `WITH expired AS \(\s+DELETE FROM urls WHERE original_url = ANY\(\$1\)`
+	ctx := context.WithValue(context.Background(), config.UserIDKeyName, "user")
+
+	links := []Link{
+		{ShortURL: "new", OriginalURL: "https://new.ru"},
+		{ShortURL: "dup", OriginalURL: "https://old.ru"},
+		{ShortURL: "taken", OriginalURL: "https://other.ru"},
+	}
+
+	s, mock := makeMockDBStorage(t)
+	batch := mock.ExpectBatch()
+	batch.ExpectExec(purgeQuery).WithArgs([]string{"https://new.ru", "https://old.ru", "https://other.ru"}).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	batch.ExpectExec(insertQuery).
+		WithArgs(pgxmock.AnyArg(), "new", "https://new.ru", "user", pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	batch.ExpectQuery(selectQuery).WithArgs("https://new.ru").
+		WillReturnRows(pgxmock.NewRows([]string{"short_url"}).AddRow("new"))
+	batch.ExpectExec(insertQuery).
+		WithArgs(pgxmock.AnyArg(), "dup", "https://old.ru", "user", pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	batch.ExpectQuery(selectQuery).WithArgs("https://old.ru").
+		WillReturnRows(pgxmock.NewRows([]string{"short_url"}).AddRow("old"))
+	batch.ExpectExec(insertQuery).
+		WithArgs(pgxmock.AnyArg(), "taken", "https://other.ru", "user", pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	batch.ExpectQuery(selectQuery).WithArgs("https://other.ru").
+		WillReturnRows(pgxmock.NewRows([]string{"short_url"}))
+
+	errs, err := s.SetBatch(ctx, links)
+	require.NoError(t, err)
+	require.Len(t, errs, 3)
+
+	assert.NoError(t, errs[0])
+
+	var keyErr *KeyError
+	require.ErrorAs(t, errs[1], &keyErr)
+	assert.ErrorIs(t, errs[1], ErrConflict)
+	assert.Equal(t, "old", keyErr.Key)
+
+	require.ErrorAs(t, errs[2], &keyErr)
+	assert.ErrorIs(t, errs[2], ErrKeyCollision)
+	assert.Equal(t, "taken", keyErr.Key)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	t.Run("backend_failure", func(t *testing.T) {
+		s, mock := makeMockDBStorage(t)
+		failure := errors.New("connection refused")
+		batch := mock.ExpectBatch()
+		batch.ExpectExec(purgeQuery).WithArgs([]string{"https://new.ru"}).
+			WillReturnResult(pgxmock.NewResult("DELETE", 0))
+		batch.ExpectExec(insertQuery).
+			WithArgs(pgxmock.AnyArg(), "new", "https://new.ru", "user", pgxmock.AnyArg()).
+			WillReturnError(failure)
+		batch.ExpectQuery(selectQuery).WithArgs("https://new.ru")
+
+		_, err := s.SetBatch(ctx, links[:1])
+		assert.ErrorIs(t, err, failure)
+	})
+}
+
+func TestDBStorageAPIKeys(t *testing.T) {
+	const keyID = "6c1f7a3e-2b0d-4e8a-9f51-0a9d3c2b7e14"
+
+	t.Run("resolve", func(t *testing.T) {
+		s, mock := makeMockDBStorage(t)
+		query := `SELECT user_uuid FROM api_keys WHERE key_hash = \$1 AND revoked_at IS NULL`
+		mock.ExpectQuery(query).WithArgs("h1").
+			WillReturnRows(pgxmock.NewRows([]string{"user_uuid"}).AddRow("owner"))
+		mock.ExpectQuery(query).WithArgs("h2").
+			WillReturnRows(pgxmock.NewRows([]string{"user_uuid"}))
+
+		userID, err := s.GetUserIDByAPIKey(context.Background(), "h1")
+		require.NoError(t, err)
+		assert.Equal(t, "owner", userID)
+
+		_, err = s.GetUserIDByAPIKey(context.Background(), "h2")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		s, mock := makeMockDBStorage(t)
+		query := `UPDATE api_keys SET revoked_at = now\(\)`
+		mock.ExpectExec(query).WithArgs(keyID, "owner").
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectExec(query).WithArgs(keyID, "stranger").
+			WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+		require.NoError(t, s.RevokeAPIKey(userCtx("owner"), keyID))
+		assert.ErrorIs(t, s.RevokeAPIKey(userCtx("stranger"), keyID), ErrNotFound)
+		assert.ErrorIs(t, s.RevokeAPIKey(userCtx("owner"), "not-a-uuid"), ErrNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBStorageDeleteBatch(t *testing.T) {
+	s, mock := makeMockDBStorage(t)
+	mock.ExpectExec(`UPDATE urls SET deleted_flag = 1\s+FROM unnest\(\$1::varchar\[\], \$2::uuid\[\]\)`).
+		WithArgs([]string{"a", "b", "c"}, []string{"user-1", "user-1", "user-2"}).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{
+		{UserID: "user-1", Keys: []string{"a", "b"}},
+		{UserID: "user-2", Keys: []string{"c"}},
+		{UserID: "user-3"},
+	}))
+	// Пустая пачка не доходит до базы.
+	require.NoError(t, s.DeleteBatch(context.Background(), nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
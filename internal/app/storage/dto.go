@@ -1,5 +1,55 @@
 package storage
 
+import "time"
+
+// Link — ссылка для сохранения; нулевой ExpiresAt означает бессрочную ссылку.
+type Link struct {
+	ShortURL    string
+	OriginalURL string
+	ExpiresAt   time.Time
+}
+
+// Deletion — ссылки Keys, которые пользователь UserID просит удалить. Чужие ссылки пропускаются.
+type Deletion struct {
+	UserID string
+	Keys   []string
+}
+
+// Click — переход по короткой ссылке.
+type Click struct {
+	ShortURL  string
+	ClickedAt time.Time
+	Referrer  string
+	UserAgent string
+	IPHash    string
+}
+
+// ClickStats — агрегированная статистика переходов по ссылке.
+// В ClicksPerDay Value — дата в формате 2006-01-02 по UTC.
+type ClickStats struct {
+	TotalClicks    int64
+	UniqueVisitors int64
+	ClicksPerDay   []CountItem
+	TopReferrers   []CountItem
+	TopUserAgents  []CountItem
+}
+
+type CountItem struct {
+	Value string
+	Count int64
+}
+
+// APIKey — ключ доступа пользователя к API. Сам ключ не хранится: только его хеш
+// и начало Prefix, по которому пользователь узнаёт ключ в списке.
+type APIKey struct {
+	ID        string
+	UserID    string
+	Name      string
+	Hash      string
+	Prefix    string
+	CreatedAt time.Time
+}
+
 type UserURL struct {
 	ShortURL    string
 	OriginalURL string
@@ -3,27 +3,58 @@ package storage
 import (
 	"context"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"time"
 )
 
 type Storage interface {
 	Load(ctx context.Context) error
-	Set(ctx context.Context, key, value string) error
-	SetBatch(ctx context.Context, keyValues map[string]string) error
-	DeleteBatch(ctx context.Context, keys []string, userID string) error
+	Set(ctx context.Context, key, value string, expiresAt time.Time) error
+	SetAlias(ctx context.Context, alias, value string, expiresAt time.Time) error
+	// SetBatch сохраняет каждую ссылку пачки отдельно и возвращает ошибки по позициям links:
+	// nil — ссылка сохранена, *KeyError с ErrConflict или ErrKeyCollision — нет.
+	SetBatch(ctx context.Context, links []Link) ([]error, error)
+	// DeleteBatch помечает удалёнными ссылки сразу нескольких пользователей.
+	DeleteBatch(ctx context.Context, deletions []Deletion) error
+	DeleteExpired(ctx context.Context) (int64, error)
+	SaveClicks(ctx context.Context, clicks []Click) error
 	Get(ctx context.Context, key string) (string, error)
 	Ping(ctx context.Context) error
 	GetByUserID(ctx context.Context) ([]map[string]string, error)
+	GetClickStats(ctx context.Context, key string, from, to time.Time) (ClickStats, error)
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	GetAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	GetUserIDByAPIKey(ctx context.Context, hash string) (string, error)
 	Close() error
 }
 
-func MakeStorage(cfg config.Config) (Storage, error) {
+// Типы хранилищ, которые выбирает MakeStorage.
+const (
+	BackendDB     = "db"
+	BackendFile   = "file"
+	BackendMemory = "memory"
+)
+
+// Backend возвращает тип хранилища, которое MakeStorage создаст по cfg.
+func Backend(cfg config.Config) string {
 	if cfg.Database.DSN != config.DefaultDatabaseDSN {
-		return MakeDBStorage(cfg.Database)
+		return BackendDB
 	}
 
 	if cfg.FileStoragePath != config.DefaultFileStoragePath {
-		return MakeFileStorage(cfg.FileStoragePath)
+		return BackendFile
 	}
 
-	return MakeMemoryStorage(), nil
+	return BackendMemory
+}
+
+func MakeStorage(cfg config.Config) (Storage, error) {
+	switch Backend(cfg) {
+	case BackendDB:
+		return MakeDBStorage(cfg.Database)
+	case BackendFile:
+		return MakeFileStorage(cfg.FileStoragePath)
+	default:
+		return MakeMemoryStorage(), nil
+	}
 }
@@ -6,21 +6,53 @@ import (
 	"errors"
 	"github.com/google/uuid"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type storageString struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserUUID    string `json:"user_uuid"`
+	UUID        string     `json:"uuid"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	UserUUID    string     `json:"user_uuid"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Alias — ссылка создана с пользовательским псевдонимом.
+	Alias bool `json:"alias,omitempty"`
+	// Deleted без OriginalURL — запись-надгробие об удалении ранее сохранённой ссылки.
+	Deleted bool `json:"deleted,omitempty"`
 }
 
+type clickString struct {
+	ShortURL  string    `json:"short_url"`
+	ClickedAt time.Time `json:"clicked_at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash"`
+}
+
+type apiKeyString struct {
+	ID        string    `json:"id"`
+	UserUUID  string    `json:"user_uuid"`
+	Name      string    `json:"name,omitempty"`
+	KeyHash   string    `json:"key_hash,omitempty"`
+	Prefix    string    `json:"prefix,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Revoked — запись об отзыве ранее сохранённого ключа.
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// fileStorage держит данные в памяти так же, как memoryStorage,
+// и дописывает каждое изменение в файл. Клики и ключи API пишутся в отдельные файлы рядом.
+// Запись в файлы идёт под той же блокировкой memoryStorage, что и изменение памяти.
 type fileStorage struct {
-	m         map[string]string
-	userLinks map[string][]string
-	file      *os.File
-	encoder   *json.Encoder
-	decoder   *json.Decoder
+	*memoryStorage
+	file          *os.File
+	encoder       *json.Encoder
+	decoder       *json.Decoder
+	clicksFile    *os.File
+	clicksEncoder *json.Encoder
+	keysFile      *os.File
+	keysEncoder   *json.Encoder
 }
 
 func MakeFileStorage(filename string) (*fileStorage, error) {
@@ -29,19 +61,37 @@ func MakeFileStorage(filename string) (*fileStorage, error) {
 		return nil, err
 	}
 
+	clicksFile, err := os.OpenFile(filename+".clicks", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	keysFile, err := os.OpenFile(filename+".keys", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		file.Close()
+		clicksFile.Close()
+		return nil, err
+	}
+
 	return &fileStorage{
-		m:         make(map[string]string),
-		userLinks: make(map[string][]string),
-		file:      file,
-		encoder:   json.NewEncoder(file),
-		decoder:   json.NewDecoder(file),
+		memoryStorage: MakeMemoryStorage(),
+		file:          file,
+		encoder:       json.NewEncoder(file),
+		decoder:       json.NewDecoder(file),
+		clicksFile:    clicksFile,
+		clicksEncoder: json.NewEncoder(clicksFile),
+		keysFile:      keysFile,
+		keysEncoder:   json.NewEncoder(keysFile),
 	}, nil
 }
 
 func (s *fileStorage) Load(ctx context.Context) error {
-	v := storageString{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	for {
+		v := storageString{}
 		err := s.decoder.Decode(&v)
 		if err != nil {
 			if err.Error() == "EOF" {
@@ -50,82 +100,365 @@ func (s *fileStorage) Load(ctx context.Context) error {
 			return err
 		}
 
-		s.m[v.ShortURL] = v.OriginalURL
-		s.userLinks[v.UserUUID] = append(s.userLinks[v.UserUUID], v.ShortURL)
+		var expiresAt time.Time
+		if v.ExpiresAt != nil {
+			expiresAt = *v.ExpiresAt
+		}
+		if isExpired(expiresAt) {
+			continue
+		}
+
+		if v.OriginalURL != "" {
+			s.put(v.UserUUID, v.ShortURL, v.OriginalURL, expiresAt, v.Alias)
+		}
+		if v.Deleted {
+			s.markDeleted(s.ownedKeys([]string{v.ShortURL}, v.UserUUID))
+		}
+	}
+
+	err := s.loadClicks()
+	if err != nil {
+		return err
+	}
+
+	return s.loadAPIKeys()
+}
+
+func (s *fileStorage) loadClicks() error {
+	dec := json.NewDecoder(s.clicksFile)
+	for {
+		v := clickString{}
+		err := dec.Decode(&v)
+		if err != nil {
+			if err.Error() == "EOF" {
+				break
+			}
+			return err
+		}
+
+		s.saveClicks([]Click{Click(v)})
 	}
 
 	return nil
 }
 
-func (s *fileStorage) Set(ctx context.Context, key, value string) error {
+func (s *fileStorage) loadAPIKeys() error {
+	dec := json.NewDecoder(s.keysFile)
+	for {
+		v := apiKeyString{}
+		err := dec.Decode(&v)
+		if err != nil {
+			if err.Error() == "EOF" {
+				break
+			}
+			return err
+		}
+
+		if v.Revoked {
+			s.revokeAPIKey(v.ID, v.UserUUID)
+			continue
+		}
+		s.putAPIKey(APIKey{
+			ID:        v.ID,
+			UserID:    v.UserUUID,
+			Name:      v.Name,
+			Hash:      v.KeyHash,
+			Prefix:    v.Prefix,
+			CreatedAt: v.CreatedAt,
+		})
+	}
+
+	return nil
+}
+
+func (s *fileStorage) Set(ctx context.Context, key, value string, expiresAt time.Time) error {
 	userID, err := getUserIDOrPanic(ctx)
 	if err != nil {
 		return err
 	}
 
-	v := storageString{
-		UUID:        uuid.New().String(),
-		ShortURL:    key,
-		OriginalURL: value,
-		UserUUID:    userID,
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.checkFree(key, value)
+	if err != nil {
+		return err
 	}
-	err = s.encoder.Encode(v)
+
+	return s.write(userID, key, value, expiresAt, false)
+}
+
+func (s *fileStorage) SetAlias(ctx context.Context, alias, value string, expiresAt time.Time) error {
+	userID, err := getUserIDOrPanic(ctx)
 	if err != nil {
 		return err
 	}
 
-	s.m[key] = value
-	s.userLinks[userID] = append(s.userLinks[userID], key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.m[alias]; ok {
+		return &KeyError{Key: alias, Err: ErrAliasTaken}
+	}
+
+	return s.write(userID, alias, value, expiresAt, true)
+}
+
+// write дописывает ссылку в файл и в память.
+func (s *fileStorage) write(userID, key, value string, expiresAt time.Time, alias bool) error {
+	v := makeStorageString(userID, key, value, expiresAt)
+	v.Alias = alias
+	err := s.encoder.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	s.put(userID, key, value, expiresAt, alias)
 	return nil
 }
 
-func (s *fileStorage) SetBatch(ctx context.Context, keyValues map[string]string) error {
-	for key, originalURL := range keyValues {
-		err := s.Set(ctx, key, originalURL)
+func (s *fileStorage) SetBatch(ctx context.Context, links []Link) ([]error, error) {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(links))
+	for i, l := range links {
+		errs[i] = s.checkFree(l.ShortURL, l.OriginalURL)
+		if errs[i] != nil {
+			continue
+		}
+
+		err = s.write(userID, l.ShortURL, l.OriginalURL, l.ExpiresAt, false)
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+	return errs, nil
+}
+
+// DeleteBatch помечает ссылки пользователей удалёнными и дописывает в файл надгробия.
+func (s *fileStorage) DeleteBatch(ctx context.Context, deletions []Deletion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range deletions {
+		owned := s.ownedKeys(d.Keys, d.UserID)
+		for _, key := range owned {
+			err := s.encoder.Encode(storageString{
+				ShortURL: key,
+				UserUUID: d.UserID,
+				Deleted:  true,
+			})
+			if err != nil {
+				return err
+			}
 		}
+
+		s.markDeleted(owned)
 	}
 	return nil
 }
 
-func (s *fileStorage) Get(ctx context.Context, key string) (string, error) {
-	v, ok := s.m[key]
-	if !ok {
-		return "", errors.New("not found")
+// DeleteExpired удаляет просроченные ссылки из памяти и переписывает файлы без них и их кликов.
+func (s *fileStorage) DeleteExpired(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.deleteExpired()
+	if n == 0 {
+		return 0, nil
 	}
-	
-	return v, nil
+
+	return n, s.rewrite()
 }
 
-func (s *fileStorage) GetByUserID(ctx context.Context) ([]map[string]string, error) {
-	var urls []map[string]string
+// rewrite переписывает файлы кликов и ссылок по текущему состоянию памяти.
+// Клики переписываются первыми: если остановиться между файлами, в файле кликов не останется
+// записей удалённых ссылок, которые при загрузке достались бы новой ссылке с тем же ключом.
+func (s *fileStorage) rewrite() error {
+	clicksFile, err := rewriteFile(s.clicksFile, s.encodeClicks)
+	if err != nil {
+		return err
+	}
+	s.clicksFile = clicksFile
+	s.clicksEncoder = json.NewEncoder(clicksFile)
 
-	userID, err := getUserIDOrPanic(ctx)
+	file, err := rewriteFile(s.file, s.encodeLinks)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.encoder = json.NewEncoder(file)
+	return nil
+}
+
+func (s *fileStorage) encodeLinks(enc *json.Encoder) error {
+	// Сначала пишем основные ключи url, чтобы при загрузке они снова стали основными.
+	for value, key := range s.keys {
+		err := enc.Encode(s.makeSnapshotString(key, value))
+		if err != nil {
+			return err
+		}
+	}
+	for key, value := range s.m {
+		if s.keys[value] == key {
+			continue
+		}
+		err := enc.Encode(s.makeSnapshotString(key, value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileStorage) encodeClicks(enc *json.Encoder) error {
+	for _, clicks := range s.clicks {
+		for _, c := range clicks {
+			err := enc.Encode(clickString(c))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteFile записывает через encode временный файл, подменяет им file
+// и возвращает заново открытый на дозапись файл вместо закрытого file.
+func rewriteFile(file *os.File, encode func(*json.Encoder) error) (*os.File, error) {
+	filename := file.Name()
+	info, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
 
-	userLinks := s.userLinks[userID]
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".short-url-db-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
 
-	for _, v := range userLinks {
-		urls = append(urls, map[string]string{
-			"short_url":    v,
-			"original_url": s.m[v],
-		})
+	err = encode(json.NewEncoder(tmp))
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return nil, err
 	}
 
-	return urls, nil
+	newFile, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, info.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return newFile, nil
 }
 
-func (s *fileStorage) DeleteBatch(ctx context.Context, keys []string, userID string) error {
+func (s *fileStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range clicks {
+		err := s.clicksEncoder.Encode(clickString(c))
+		if err != nil {
+			return err
+		}
+	}
+
+	s.saveClicks(clicks)
 	return nil
 }
 
-func (s *fileStorage) Ping(ctx context.Context) error {
+func (s *fileStorage) SaveAPIKey(ctx context.Context, key APIKey) error {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.UserID = userID
+	err = s.keysEncoder.Encode(apiKeyString{
+		ID:        key.ID,
+		UserUUID:  key.UserID,
+		Name:      key.Name,
+		KeyHash:   key.Hash,
+		Prefix:    key.Prefix,
+		CreatedAt: key.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.putAPIKey(key)
 	return nil
 }
 
+// RevokeAPIKey удаляет ключ из памяти и дописывает в файл запись об отзыве.
+func (s *fileStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.apiKeys[id]; !ok || key.UserID != userID {
+		return ErrNotFound
+	}
+
+	err = s.keysEncoder.Encode(apiKeyString{
+		ID:       id,
+		UserUUID: userID,
+		Revoked:  true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.revokeAPIKey(id, userID)
+}
+
 func (s *fileStorage) Close() error {
-	return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return errors.Join(s.file.Close(), s.clicksFile.Close(), s.keysFile.Close())
+}
+
+// makeSnapshotString описывает текущее состояние ссылки для перезаписи файла.
+func (s *fileStorage) makeSnapshotString(key, value string) storageString {
+	v := makeStorageString(s.owners[key], key, value, s.expiresAt[key])
+	v.Alias = s.aliases[key]
+	v.Deleted = s.deleted[key]
+	return v
+}
+
+func makeStorageString(userID, key, value string, expiresAt time.Time) storageString {
+	v := storageString{
+		UUID:        uuid.New().String(),
+		ShortURL:    key,
+		OriginalURL: value,
+		UserUUID:    userID,
+	}
+	if !expiresAt.IsZero() {
+		v.ExpiresAt = &expiresAt
+	}
+	return v
 }
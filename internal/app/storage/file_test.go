@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageDeleteBatchSurvivesReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	s, err := MakeFileStorage(filename)
+	require.NoError(t, err)
+	require.NoError(t, s.Load(context.Background()))
+	require.NoError(t, s.Set(userCtx("owner"), "abc", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.Set(userCtx("owner"), "def", "https://r0.ru", time.Time{}))
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{{UserID: "stranger", Keys: []string{"abc"}}}))
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{{UserID: "owner", Keys: []string{"def"}}}))
+	require.NoError(t, s.Close())
+
+	s, err = MakeFileStorage(filename)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Load(context.Background()))
+
+	v, err := s.Get(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", v)
+
+	_, err = s.Get(context.Background(), "def")
+	assert.ErrorIs(t, err, ErrDeleted)
+}
+
+func TestFileStorageRewriteKeepsDeleted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	s, err := MakeFileStorage(filename)
+	require.NoError(t, err)
+	require.NoError(t, s.Load(context.Background()))
+	require.NoError(t, s.Set(userCtx("owner"), "abc", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.Set(userCtx("owner"), "old", "https://r0.ru", time.Now().Add(-time.Second)))
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{{UserID: "owner", Keys: []string{"abc"}}}))
+
+	n, err := s.DeleteExpired(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	require.NoError(t, s.Close())
+
+	s, err = MakeFileStorage(filename)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Load(context.Background()))
+
+	_, err = s.Get(context.Background(), "abc")
+	assert.ErrorIs(t, err, ErrDeleted)
+	_, ok := s.m["old"]
+	assert.False(t, ok)
+}
+
+func TestFileStorageGetNotFound(t *testing.T) {
+	s, err := MakeFileStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Load(context.Background()))
+
+	_, err = s.Get(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStorageAPIKeysSurviveReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+	now := time.Now().UTC()
+
+	s, err := MakeFileStorage(filename)
+	require.NoError(t, err)
+	require.NoError(t, s.Load(context.Background()))
+	require.NoError(t, s.SaveAPIKey(userCtx("owner"), APIKey{ID: "k1", Name: "ci", Hash: "h1", Prefix: "sk_1", CreatedAt: now}))
+	require.NoError(t, s.SaveAPIKey(userCtx("owner"), APIKey{ID: "k2", Name: "cron", Hash: "h2", Prefix: "sk_2", CreatedAt: now.Add(time.Second)}))
+	assert.ErrorIs(t, s.RevokeAPIKey(userCtx("stranger"), "k1"), ErrNotFound)
+	require.NoError(t, s.RevokeAPIKey(userCtx("owner"), "k2"))
+	require.NoError(t, s.Close())
+
+	s, err = MakeFileStorage(filename)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Load(context.Background()))
+
+	userID, err := s.GetUserIDByAPIKey(context.Background(), "h1")
+	require.NoError(t, err)
+	assert.Equal(t, "owner", userID)
+
+	_, err = s.GetUserIDByAPIKey(context.Background(), "h2")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	keys, err := s.GetAPIKeys(userCtx("owner"))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "ci", keys[0].Name)
+	assert.True(t, now.Equal(keys[0].CreatedAt))
+}
+
+func TestFileStorageRewriteDropsClicksOfPurgedLinks(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	s, err := MakeFileStorage(filename)
+	require.NoError(t, err)
+	require.NoError(t, s.Load(context.Background()))
+	require.NoError(t, s.SetAlias(userCtx("owner"), "promo", "https://ya.ru", time.Now().Add(time.Hour)))
+	require.NoError(t, s.SetAlias(userCtx("owner"), "keep", "https://r0.ru", time.Time{}))
+	require.NoError(t, s.SaveClicks(context.Background(), []Click{
+		{ShortURL: "promo", ClickedAt: time.Now(), IPHash: "a"},
+		{ShortURL: "keep", ClickedAt: time.Now(), IPHash: "b"},
+	}))
+
+	s.expiresAt["promo"] = time.Now().Add(-time.Second)
+	n, err := s.DeleteExpired(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+
+	// Псевдоним освободился и достался другому пользователю.
+	require.NoError(t, s.SetAlias(userCtx("stranger"), "promo", "https://practicum.yandex.ru", time.Time{}))
+	require.NoError(t, s.Close())
+
+	s, err = MakeFileStorage(filename)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Load(context.Background()))
+
+	stats, err := s.GetClickStats(userCtx("stranger"), "promo", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Zero(t, stats.TotalClicks, "клики прежнего владельца не должны достаться новому")
+
+	stats, err = s.GetClickStats(userCtx("owner"), "keep", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stats.TotalClicks)
+}
+
+func TestFileStorageAliasSurvivesRewrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	s, err := MakeFileStorage(filename)
+	require.NoError(t, err)
+	require.NoError(t, s.Load(context.Background()))
+	require.NoError(t, s.SetAlias(userCtx("owner"), "promo", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.Set(userCtx("owner"), "old", "https://r0.ru", time.Now().Add(-time.Second)))
+	_, err = s.DeleteExpired(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = MakeFileStorage(filename)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Load(context.Background()))
+
+	assert.NoError(t, s.Set(userCtx("owner"), "abc", "https://ya.ru", time.Time{}), "псевдоним после перезаписи файла не стал основным ключом")
+}
@@ -2,18 +2,41 @@ package storage
 
 import (
 	"context"
-	"errors"
+	"sort"
+	"sync"
+	"time"
 )
 
+// memoryStorage защищает всё состояние одним RWMutex: методы с заглавной буквы
+// берут блокировку, а вспомогательные методы со строчной ожидают, что она уже взята.
 type memoryStorage struct {
+	mu        sync.RWMutex
 	m         map[string]string
+	keys      map[string]string
+	owners    map[string]string
+	expiresAt map[string]time.Time
+	deleted   map[string]bool
+	// aliases — ключи-псевдонимы: они не становятся основным ключом url в keys.
+	aliases   map[string]bool
 	userLinks map[string][]string
+	clicks    map[string][]Click
+	apiKeys   map[string]APIKey
+	// apiKeyIDs ищет id ключа по его хешу.
+	apiKeyIDs map[string]string
 }
 
 func MakeMemoryStorage() *memoryStorage {
 	return &memoryStorage{
 		m:         make(map[string]string),
+		keys:      make(map[string]string),
+		owners:    make(map[string]string),
+		expiresAt: make(map[string]time.Time),
+		deleted:   make(map[string]bool),
+		aliases:   make(map[string]bool),
 		userLinks: make(map[string][]string),
+		clicks:    make(map[string][]Click),
+		apiKeys:   make(map[string]APIKey),
+		apiKeyIDs: make(map[string]string),
 	}
 }
 
@@ -21,31 +44,132 @@ func (s *memoryStorage) Load(ctx context.Context) error {
 	return nil
 }
 
-func (s *memoryStorage) Set(ctx context.Context, key, value string) error {
+func (s *memoryStorage) Set(ctx context.Context, key, value string, expiresAt time.Time) error {
 	userID, err := getUserIDOrPanic(ctx)
 	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.checkFree(key, value)
+	if err != nil {
+		return err
+	}
+
+	s.put(userID, key, value, expiresAt, false)
+	return nil
+}
+
+func (s *memoryStorage) SetAlias(ctx context.Context, alias, value string, expiresAt time.Time) error {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.m[alias]; ok {
+		return &KeyError{Key: alias, Err: ErrAliasTaken}
+	}
+
+	s.put(userID, alias, value, expiresAt, true)
+	return nil
+}
+
+// checkFree проверяет, что у url ещё нет основной ссылки, а ключ не занят другим url.
+// Псевдонимы, удалённые и просроченные ссылки url не занимают, но их ключи заняты до очистки.
+func (s *memoryStorage) checkFree(key, value string) error {
+	if existingKey, ok := s.keys[value]; ok && s.isLive(existingKey) {
+		return &KeyError{Key: existingKey, Err: ErrConflict}
+	}
+	if _, ok := s.m[key]; ok {
+		return &KeyError{Key: key, Err: ErrKeyCollision}
+	}
+	return nil
+}
+
+// isLive сообщает, что ссылка key сохранена, не удалена и не просрочена.
+func (s *memoryStorage) isLive(key string) bool {
+	_, ok := s.m[key]
+	return ok && !s.deleted[key] && !isExpired(s.expiresAt[key])
+}
+
+// put сохраняет ссылку. Ссылка, не являющаяся псевдонимом, становится основным ключом url,
+// если у url нет живого основного ключа.
+func (s *memoryStorage) put(userID, key, value string, expiresAt time.Time, alias bool) {
 	s.m[key] = value
+	if alias {
+		s.aliases[key] = true
+	} else if existingKey, ok := s.keys[value]; !ok || !s.isLive(existingKey) {
+		s.keys[value] = key
+	}
+	s.owners[key] = userID
+	if !expiresAt.IsZero() {
+		s.expiresAt[key] = expiresAt
+	}
 	s.userLinks[userID] = append(s.userLinks[userID], key)
-	return nil
 }
 
-func (s *memoryStorage) SetBatch(ctx context.Context, keyValues map[string]string) error {
-	for key, originalURL := range keyValues {
-		err := s.Set(ctx, key, originalURL)
-		if err != nil {
-			return err
+func (s *memoryStorage) remove(key string) {
+	value := s.m[key]
+	if s.keys[value] == key {
+		delete(s.keys, value)
+	}
+
+	userID := s.owners[key]
+	userLinks := s.userLinks[userID]
+	for i, v := range userLinks {
+		if v == key {
+			s.userLinks[userID] = append(userLinks[:i:i], userLinks[i+1:]...)
+			break
 		}
 	}
-	return nil
+
+	delete(s.m, key)
+	delete(s.owners, key)
+	delete(s.expiresAt, key)
+	delete(s.deleted, key)
+	delete(s.aliases, key)
+	delete(s.clicks, key)
+}
+
+func (s *memoryStorage) SetBatch(ctx context.Context, links []Link) ([]error, error) {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(links))
+	for i, l := range links {
+		errs[i] = s.checkFree(l.ShortURL, l.OriginalURL)
+		if errs[i] == nil {
+			s.put(userID, l.ShortURL, l.OriginalURL, l.ExpiresAt, false)
+		}
+	}
+	return errs, nil
 }
 
 func (s *memoryStorage) Get(ctx context.Context, key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	v, ok := s.m[key]
 	if !ok {
-		return "", errors.New("not found")
+		return "", ErrNotFound
+	}
+
+	if s.deleted[key] {
+		return "", ErrDeleted
+	}
+
+	if isExpired(s.expiresAt[key]) {
+		return "", ErrExpired
 	}
 
 	return v, nil
@@ -59,6 +183,9 @@ func (s *memoryStorage) GetByUserID(ctx context.Context) ([]map[string]string, e
 		return nil, err
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	userLinks := s.userLinks[userID]
 
 	for _, v := range userLinks {
@@ -71,10 +198,159 @@ func (s *memoryStorage) GetByUserID(ctx context.Context) ([]map[string]string, e
 	return urls, nil
 }
 
-func (s *memoryStorage) DeleteBatch(ctx context.Context, keys []string, userID string) error {
+func (s *memoryStorage) DeleteBatch(ctx context.Context, deletions []Deletion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range deletions {
+		s.markDeleted(s.ownedKeys(d.Keys, d.UserID))
+	}
+	return nil
+}
+
+// ownedKeys оставляет из keys только ссылки пользователя userID.
+func (s *memoryStorage) ownedKeys(keys []string, userID string) []string {
+	var owned []string
+	for _, key := range keys {
+		if owner, ok := s.owners[key]; ok && owner == userID {
+			owned = append(owned, key)
+		}
+	}
+	return owned
+}
+
+func (s *memoryStorage) markDeleted(keys []string) {
+	for _, key := range keys {
+		s.deleted[key] = true
+	}
+}
+
+func (s *memoryStorage) DeleteExpired(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteExpired(), nil
+}
+
+func (s *memoryStorage) deleteExpired() int64 {
+	var n int64
+	for key, expiresAt := range s.expiresAt {
+		if isExpired(expiresAt) {
+			s.remove(key)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *memoryStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saveClicks(clicks)
+	return nil
+}
+
+func (s *memoryStorage) saveClicks(clicks []Click) {
+	for _, c := range clicks {
+		if _, ok := s.m[c.ShortURL]; !ok {
+			continue
+		}
+		s.clicks[c.ShortURL] = append(s.clicks[c.ShortURL], c)
+	}
+}
+
+func (s *memoryStorage) GetClickStats(ctx context.Context, key string, from, to time.Time) (ClickStats, error) {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return ClickStats{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if owner, ok := s.owners[key]; !ok || owner != userID {
+		return ClickStats{}, ErrNotOwned
+	}
+
+	return aggregateClicks(s.clicks[key], from, to), nil
+}
+
+func (s *memoryStorage) SaveAPIKey(ctx context.Context, key APIKey) error {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.UserID = userID
+	s.putAPIKey(key)
 	return nil
 }
 
+func (s *memoryStorage) putAPIKey(key APIKey) {
+	s.apiKeys[key.ID] = key
+	s.apiKeyIDs[key.Hash] = key.ID
+}
+
+func (s *memoryStorage) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []APIKey
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *memoryStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	userID, err := getUserIDOrPanic(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.revokeAPIKey(id, userID)
+}
+
+// revokeAPIKey удаляет ключ id пользователя userID, чужой или неизвестный ключ — ErrNotFound.
+func (s *memoryStorage) revokeAPIKey(id, userID string) error {
+	key, ok := s.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrNotFound
+	}
+
+	delete(s.apiKeys, id)
+	delete(s.apiKeyIDs, key.Hash)
+	return nil
+}
+
+func (s *memoryStorage) GetUserIDByAPIKey(ctx context.Context, hash string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.apiKeyIDs[hash]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return s.apiKeys[id].UserID, nil
+}
+
 func (s *memoryStorage) Ping(ctx context.Context) error {
 	return nil
 }
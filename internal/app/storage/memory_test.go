@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func userCtx(userID string) context.Context {
+	return context.WithValue(context.Background(), config.UserIDKeyName, userID)
+}
+
+func TestMemoryStorageDeleteBatch(t *testing.T) {
+	s := MakeMemoryStorage()
+	require.NoError(t, s.Set(userCtx("owner"), "abc", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.Set(userCtx("owner"), "def", "https://r0.ru", time.Time{}))
+
+	// Чужие ссылки не удаляются.
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{{UserID: "stranger", Keys: []string{"abc"}}}))
+	v, err := s.Get(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", v)
+
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{{UserID: "owner", Keys: []string{"abc", "unknown"}}}))
+	_, err = s.Get(context.Background(), "abc")
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	v, err = s.Get(context.Background(), "def")
+	require.NoError(t, err)
+	assert.Equal(t, "https://r0.ru", v)
+}
+
+func TestMemoryStorageGet(t *testing.T) {
+	s := MakeMemoryStorage()
+	require.NoError(t, s.Set(userCtx("owner"), "abc", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.Set(userCtx("owner"), "old", "https://r0.ru", time.Now().Add(-time.Second)))
+	require.NoError(t, s.Set(userCtx("owner"), "del", "https://practicum.yandex.ru", time.Time{}))
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{{UserID: "owner", Keys: []string{"del"}}}))
+
+	v, err := s.Get(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", v)
+
+	_, err = s.Get(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = s.Get(context.Background(), "old")
+	assert.ErrorIs(t, err, ErrExpired)
+
+	_, err = s.Get(context.Background(), "del")
+	assert.ErrorIs(t, err, ErrDeleted)
+}
+
+func TestMemoryStorageSetBatch(t *testing.T) {
+	s := MakeMemoryStorage()
+	require.NoError(t, s.Set(userCtx("owner"), "abc", "https://ya.ru", time.Time{}))
+
+	errs, err := s.SetBatch(userCtx("owner"), []Link{
+		{ShortURL: "new", OriginalURL: "https://r0.ru"},
+		{ShortURL: "dup", OriginalURL: "https://ya.ru"},
+		{ShortURL: "abc", OriginalURL: "https://practicum.yandex.ru"},
+	})
+	require.NoError(t, err)
+	require.Len(t, errs, 3)
+
+	// Ошибка одной ссылки не мешает сохранить остальные.
+	assert.NoError(t, errs[0])
+	v, err := s.Get(context.Background(), "new")
+	require.NoError(t, err)
+	assert.Equal(t, "https://r0.ru", v)
+
+	var keyErr *KeyError
+	require.ErrorAs(t, errs[1], &keyErr)
+	assert.ErrorIs(t, errs[1], ErrConflict)
+	assert.Equal(t, "abc", keyErr.Key)
+
+	assert.ErrorIs(t, errs[2], ErrKeyCollision)
+}
+
+func TestMemoryStorageSetAfterDeleteAndExpiry(t *testing.T) {
+	s := MakeMemoryStorage()
+	require.NoError(t, s.Set(userCtx("owner"), "del", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.Set(userCtx("owner"), "old", "https://r0.ru", time.Now().Add(-time.Second)))
+	require.NoError(t, s.DeleteBatch(context.Background(), []Deletion{{UserID: "owner", Keys: []string{"del"}}}))
+
+	// Удалённая и просроченная ссылки не мешают сократить их url заново.
+	require.NoError(t, s.Set(userCtx("owner"), "new", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.Set(userCtx("owner"), "fresh", "https://r0.ru", time.Time{}))
+
+	// Теперь url снова занят — новой ссылкой.
+	err := s.Set(userCtx("owner"), "again", "https://ya.ru", time.Time{})
+	var keyErr *KeyError
+	require.ErrorAs(t, err, &keyErr)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, "new", keyErr.Key)
+
+	// Ключ удалённой ссылки занят до очистки.
+	assert.ErrorIs(t, s.Set(userCtx("owner"), "del", "https://practicum.yandex.ru", time.Time{}), ErrKeyCollision)
+}
+
+func TestMemoryStorageAliasIsNotPrimaryKey(t *testing.T) {
+	s := MakeMemoryStorage()
+	require.NoError(t, s.SetAlias(userCtx("owner"), "promo", "https://ya.ru", time.Time{}))
+
+	// Псевдоним не мешает сократить url, и конфликт дальше указывает на основной ключ.
+	require.NoError(t, s.Set(userCtx("owner"), "abc", "https://ya.ru", time.Time{}))
+	require.NoError(t, s.SetAlias(userCtx("owner"), "promo2", "https://ya.ru", time.Time{}))
+
+	err := s.Set(userCtx("owner"), "def", "https://ya.ru", time.Time{})
+	var keyErr *KeyError
+	require.ErrorAs(t, err, &keyErr)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, "abc", keyErr.Key)
+}
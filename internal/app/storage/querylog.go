@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/jackc/pgx/v5"
+)
+
+type queryLogKey struct{}
+
+// loggedQuery — запрос, который queryLogger запомнил в начале, чтобы записать его в конце.
+type loggedQuery struct {
+	sql   string
+	start time.Time
+}
+
+// queryLogger пишет запросы к базе в логгер запроса из контекста, поэтому записи хранилища
+// несут id запроса и пользователя. Успешные запросы пишутся на уровне debug, ошибки — warn:
+// решает, сбой это или нет, вызывающий код.
+type queryLogger struct{}
+
+func (l queryLogger) start(ctx context.Context, sql string) context.Context {
+	return context.WithValue(ctx, queryLogKey{}, loggedQuery{sql: sql, start: time.Now()})
+}
+
+func (l queryLogger) end(ctx context.Context, err error) {
+	q, ok := ctx.Value(queryLogKey{}).(loggedQuery)
+	if !ok {
+		return
+	}
+
+	log := logger.FromContext(ctx)
+	if err != nil {
+		log.Warnw("Ошибка запроса к базе", "sql", q.sql, "duration", time.Since(q.start), logger.FieldError, err)
+		return
+	}
+	log.Debugw("Запрос к базе", "sql", q.sql, "duration", time.Since(q.start))
+}
+
+func (l queryLogger) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return l.start(ctx, data.SQL)
+}
+
+func (l queryLogger) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	l.end(ctx, data.Err)
+}
+
+func (l queryLogger) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	return l.start(ctx, "BATCH")
+}
+
+func (l queryLogger) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	if data.Err != nil {
+		logger.FromContext(ctx).Warnw("Ошибка запроса к базе", "sql", data.SQL, logger.FieldError, data.Err)
+	}
+}
+
+func (l queryLogger) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	l.end(ctx, data.Err)
+}
+
+func (l queryLogger) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return l.start(ctx, "COPY "+data.TableName.Sanitize())
+}
+
+func (l queryLogger) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	l.end(ctx, data.Err)
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/eduardtungatarov/shortener/internal/app/logger"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestQueryLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := logger.WithLogger(context.Background(), zap.New(core).Sugar().With(logger.FieldRequestID, "req-1"))
+	l := queryLogger{}
+
+	const query = "SELECT original_url FROM urls WHERE short_url = $1"
+	qctx := l.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: query})
+	l.TraceQueryEnd(qctx, nil, pgx.TraceQueryEndData{})
+	qctx = l.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: query})
+	l.TraceQueryEnd(qctx, nil, pgx.TraceQueryEndData{Err: errors.New("connection reset")})
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 2)
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.Equal(t, zapcore.WarnLevel, entries[1].Level)
+	for _, e := range entries {
+		assert.Equal(t, "req-1", e.ContextMap()[logger.FieldRequestID], "записи хранилища несут id запроса")
+		assert.Equal(t, query, e.ContextMap()["sql"])
+	}
+	assert.Equal(t, "connection reset", entries[1].ContextMap()[logger.FieldError])
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// topLimit — сколько значений попадает в топы рефереров и user agent.
+const topLimit = 10
+
+// aggregateClicks считает статистику по кликам из [from, to); нулевые границы не ограничивают выборку.
+func aggregateClicks(clicks []Click, from, to time.Time) ClickStats {
+	var stats ClickStats
+	visitors := make(map[string]bool)
+	days := make(map[string]int64)
+	referrers := make(map[string]int64)
+	userAgents := make(map[string]int64)
+
+	for _, c := range clicks {
+		if !from.IsZero() && c.ClickedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !c.ClickedAt.Before(to) {
+			continue
+		}
+
+		stats.TotalClicks++
+		visitors[c.IPHash] = true
+		days[c.ClickedAt.UTC().Format(time.DateOnly)]++
+		if c.Referrer != "" {
+			referrers[c.Referrer]++
+		}
+		if c.UserAgent != "" {
+			userAgents[c.UserAgent]++
+		}
+	}
+
+	stats.UniqueVisitors = int64(len(visitors))
+	for day, n := range days {
+		stats.ClicksPerDay = append(stats.ClicksPerDay, CountItem{Value: day, Count: n})
+	}
+	sort.Slice(stats.ClicksPerDay, func(i, j int) bool {
+		return stats.ClicksPerDay[i].Value < stats.ClicksPerDay[j].Value
+	})
+	stats.TopReferrers = top(referrers)
+	stats.TopUserAgents = top(userAgents)
+
+	return stats
+}
+
+func top(counts map[string]int64) []CountItem {
+	items := make([]CountItem, 0, len(counts))
+	for v, n := range counts {
+		items = append(items, CountItem{Value: v, Count: n})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Value < items[j].Value
+	})
+	if len(items) > topLimit {
+		items = items[:topLimit]
+	}
+	return items
+}
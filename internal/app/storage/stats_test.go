@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateClicks(t *testing.T) {
+	day1 := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 10, 2, 10, 0, 0, 0, time.UTC)
+	clicks := []Click{
+		{ClickedAt: day1, Referrer: "https://ya.ru/", UserAgent: "curl", IPHash: "a"},
+		{ClickedAt: day1, Referrer: "https://ya.ru/", UserAgent: "firefox", IPHash: "a"},
+		{ClickedAt: day2, Referrer: "", UserAgent: "curl", IPHash: "b"},
+	}
+
+	stats := aggregateClicks(clicks, time.Time{}, time.Time{})
+	assert.EqualValues(t, 3, stats.TotalClicks)
+	assert.EqualValues(t, 2, stats.UniqueVisitors)
+	assert.Equal(t, []CountItem{{"2026-10-01", 2}, {"2026-10-02", 1}}, stats.ClicksPerDay)
+	assert.Equal(t, []CountItem{{"https://ya.ru/", 2}}, stats.TopReferrers)
+	assert.Equal(t, []CountItem{{"curl", 2}, {"firefox", 1}}, stats.TopUserAgents)
+
+	// Верхняя граница не включается.
+	stats = aggregateClicks(clicks, day1, day2)
+	assert.EqualValues(t, 2, stats.TotalClicks)
+	assert.EqualValues(t, 1, stats.UniqueVisitors)
+
+	stats = aggregateClicks(clicks, day2, time.Time{})
+	assert.EqualValues(t, 1, stats.TotalClicks)
+}
+
+func TestTopIsLimited(t *testing.T) {
+	counts := make(map[string]int64)
+	for i := 0; i < topLimit+5; i++ {
+		counts[fmt.Sprintf("ua-%02d", i)] = int64(i)
+	}
+
+	items := top(counts)
+	assert.Len(t, items, topLimit)
+	assert.Equal(t, CountItem{"ua-14", 14}, items[0])
+}
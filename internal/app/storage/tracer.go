@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer открывает спан на каждый запрос пула с текстом SQL в атрибуте db.query.text.
+// Аргументы запроса в спан не попадают: в них адреса ссылок и идентификаторы пользователей.
+type queryTracer struct {
+	tracer trace.Tracer
+}
+
+func makeQueryTracer() *queryTracer {
+	return &queryTracer{tracer: otel.Tracer("github.com/eduardtungatarov/shortener/internal/app/storage")}
+}
+
+func (t *queryTracer) start(ctx context.Context, name string, attrs ...attribute.KeyValue) context.Context {
+	ctx, _ = t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+		trace.WithAttributes(attrs...),
+	)
+	return ctx
+}
+
+func (t *queryTracer) end(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// operationName — первое слово запроса: SELECT, INSERT и т. д.
+func operationName(sql string) string {
+	op, _, _ := strings.Cut(strings.TrimSpace(sql), " ")
+	return strings.ToUpper(op)
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	op := operationName(data.SQL)
+	return t.start(ctx, "db "+op, semconv.DBOperationName(op), semconv.DBQueryText(data.SQL))
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, data.Err)
+}
+
+// TraceBatchStart открывает один спан на всю пачку, запросы пачки записываются в него событиями.
+func (t *queryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return t.start(ctx, "db BATCH", semconv.DBOperationName("BATCH"), attribute.Int("db.batch.size", data.Batch.Len()))
+}
+
+func (t *queryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	trace.SpanFromContext(ctx).AddEvent("query", trace.WithAttributes(semconv.DBQueryText(data.SQL)))
+}
+
+func (t *queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	t.end(ctx, data.Err)
+}
+
+func (t *queryTracer) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	table := data.TableName.Sanitize()
+	return t.start(ctx, "db COPY "+table,
+		semconv.DBOperationName("COPY"),
+		semconv.DBCollectionName(table),
+		semconv.DBQueryText("COPY "+table+" ("+strings.Join(data.ColumnNames, ", ")+") FROM STDIN"),
+	)
+}
+
+func (t *queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, data.Err)
+}
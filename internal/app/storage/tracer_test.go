@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func TestQueryTracer(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tr := &queryTracer{tracer: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")}
+
+	const query = "SELECT original_url FROM urls WHERE short_url = $1"
+	ctx := tr.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: query, Args: []any{"abc"}})
+	tr.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+
+	b := &pgx.Batch{}
+	b.Queue("INSERT INTO clicks VALUES ($1)", "abc")
+	ctx = tr.TraceBatchStart(context.Background(), nil, pgx.TraceBatchStartData{Batch: b})
+	tr.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: "INSERT INTO clicks VALUES ($1)"})
+	tr.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{Err: errors.New("connection reset")})
+
+	spans := rec.Ended()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "db SELECT", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), semconv.DBQueryText(query))
+	assert.Contains(t, spans[0].Attributes(), semconv.DBSystemPostgreSQL)
+	for _, a := range spans[0].Attributes() {
+		assert.NotEqual(t, "abc", a.Value.Emit(), "аргументы запроса в спан не попадают")
+	}
+
+	assert.Equal(t, "db BATCH", spans[1].Name())
+	require.Len(t, spans[1].Events(), 2, "запрос пачки и ошибка")
+	assert.Contains(t, spans[1].Events()[0].Attributes, semconv.DBQueryText("INSERT INTO clicks VALUES ($1)"))
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
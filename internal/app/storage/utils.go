@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"time"
 )
 
 func getUserIDOrPanic(ctx context.Context) (string, error) {
@@ -12,3 +13,29 @@ func getUserIDOrPanic(ctx context.Context) (string, error) {
 	}
 	return "", errors.New("userID not found or not a string")
 }
+
+func isExpired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !expiresAt.After(time.Now())
+}
+
+// dataErrors — ответы хранилища, которые описывают данные, а не сбой.
+var dataErrors = []error{
+	ErrNotFound,
+	ErrDeleted,
+	ErrExpired,
+	ErrConflict,
+	ErrKeyCollision,
+	ErrAliasTaken,
+	ErrNotOwned,
+}
+
+// IsDataError сообщает, что err — ответ о данных: ссылка не найдена, занята и т. п.
+// Такие ошибки не считаются сбоями хранилища в метриках и трассировке.
+func IsDataError(err error) bool {
+	for _, e := range dataErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithRequests открывает серверный спан запроса, продолжая трассу из заголовка traceparent.
+// Как и в метриках, спан называется по шаблону маршрута, который известен только после next.
+func WithRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer().Start(ctx, req.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.URLPath(req.URL.Path),
+			),
+		)
+		defer span.End()
+
+		ww := chimw.NewWrapResponseWriter(res, req.ProtoMajor)
+		next.ServeHTTP(ww, req.WithContext(ctx))
+
+		if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(req.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		// Ответы 4xx — ошибки клиента, серверный спан ошибкой не считается.
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+type middlewareSpanKey struct{}
+
+// middlewareSpan — спан middleware и спан, внутри которого её вызвали.
+type middlewareSpan struct {
+	span   trace.Span
+	parent trace.Span
+}
+
+// Middleware оборачивает mw спаном с именем name. Спан заканчивается, когда mw передаёт запрос
+// дальше или сама отвечает клиенту, поэтому его длительность — время работы только этой middleware.
+// Следующие middleware и обработчик получают спаны-соседи, а не вложенные: иначе каждый спан
+// включал бы время всей оставшейся цепочки.
+func Middleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := mw(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			if ms, ok := ctx.Value(middlewareSpanKey{}).(*middlewareSpan); ok {
+				ms.span.End()
+				// Значения, которые mw положила в контекст, сохраняются, меняется только текущий спан.
+				ctx = trace.ContextWithSpan(ctx, ms.parent)
+			}
+			next.ServeHTTP(res, req.WithContext(ctx))
+		}))
+
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			parent := trace.SpanFromContext(req.Context())
+			ctx, span := tracer().Start(req.Context(), "middleware "+name)
+			ctx = context.WithValue(ctx, middlewareSpanKey{}, &middlewareSpan{span: span, parent: parent})
+
+			h.ServeHTTP(res, req.WithContext(ctx))
+			// Если mw ответила сама, спан ещё открыт; повторный End ничего не делает.
+			span.End()
+		})
+	}
+}
+
+// Handler оборачивает обработчик спаном с именем name.
+func Handler(name string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer().Start(req.Context(), "handler "+name)
+		defer span.End()
+
+		fn(res, req.WithContext(ctx))
+	}
+}
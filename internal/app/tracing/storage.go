@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// backendKey — атрибут спана с типом хранилища: storage.BackendDB, BackendFile или BackendMemory.
+const backendKey = attribute.Key("storage.backend")
+
+// tracedStorage открывает спан на каждый вызов хранилища. Запросы к базе внутри вызова
+// получают свои спаны с текстом SQL уже в самом хранилище.
+type tracedStorage struct {
+	s       storage.Storage
+	backend string
+}
+
+// InstrumentStorage оборачивает s спанами вызовов.
+func InstrumentStorage(s storage.Storage, backend string) storage.Storage {
+	return &tracedStorage{s: s, backend: backend}
+}
+
+func (s *tracedStorage) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(backendKey.String(s.backend)),
+	)
+}
+
+// end закрывает спан. Ответы о данных вроде ErrNotFound ошибкой спана не считаются.
+func end(span trace.Span, err error) {
+	if err != nil && !storage.IsDataError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *tracedStorage) Load(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Load")
+	err := s.s.Load(ctx)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) Set(ctx context.Context, key, value string, expiresAt time.Time) error {
+	ctx, span := s.start(ctx, "Set")
+	err := s.s.Set(ctx, key, value, expiresAt)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) SetAlias(ctx context.Context, alias, value string, expiresAt time.Time) error {
+	ctx, span := s.start(ctx, "SetAlias")
+	err := s.s.SetAlias(ctx, alias, value, expiresAt)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) SetBatch(ctx context.Context, links []storage.Link) ([]error, error) {
+	ctx, span := s.start(ctx, "SetBatch")
+	span.SetAttributes(attribute.Int("storage.batch_size", len(links)))
+	errs, err := s.s.SetBatch(ctx, links)
+	end(span, err)
+	return errs, err
+}
+
+func (s *tracedStorage) DeleteBatch(ctx context.Context, deletions []storage.Deletion) error {
+	ctx, span := s.start(ctx, "DeleteBatch")
+	span.SetAttributes(attribute.Int("storage.batch_size", len(deletions)))
+	err := s.s.DeleteBatch(ctx, deletions)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, span := s.start(ctx, "DeleteExpired")
+	n, err := s.s.DeleteExpired(ctx)
+	end(span, err)
+	return n, err
+}
+
+func (s *tracedStorage) SaveClicks(ctx context.Context, clicks []storage.Click) error {
+	ctx, span := s.start(ctx, "SaveClicks")
+	span.SetAttributes(attribute.Int("storage.batch_size", len(clicks)))
+	err := s.s.SaveClicks(ctx, clicks)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) Get(ctx context.Context, key string) (string, error) {
+	ctx, span := s.start(ctx, "Get")
+	v, err := s.s.Get(ctx, key)
+	end(span, err)
+	return v, err
+}
+
+func (s *tracedStorage) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Ping")
+	err := s.s.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetByUserID(ctx context.Context) ([]map[string]string, error) {
+	ctx, span := s.start(ctx, "GetByUserID")
+	urls, err := s.s.GetByUserID(ctx)
+	end(span, err)
+	return urls, err
+}
+
+func (s *tracedStorage) GetClickStats(ctx context.Context, key string, from, to time.Time) (storage.ClickStats, error) {
+	ctx, span := s.start(ctx, "GetClickStats")
+	stats, err := s.s.GetClickStats(ctx, key, from, to)
+	end(span, err)
+	return stats, err
+}
+
+func (s *tracedStorage) SaveAPIKey(ctx context.Context, key storage.APIKey) error {
+	ctx, span := s.start(ctx, "SaveAPIKey")
+	err := s.s.SaveAPIKey(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetAPIKeys(ctx context.Context) ([]storage.APIKey, error) {
+	ctx, span := s.start(ctx, "GetAPIKeys")
+	keys, err := s.s.GetAPIKeys(ctx)
+	end(span, err)
+	return keys, err
+}
+
+func (s *tracedStorage) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, span := s.start(ctx, "RevokeAPIKey")
+	err := s.s.RevokeAPIKey(ctx, id)
+	end(span, err)
+	return err
+}
+
+func (s *tracedStorage) GetUserIDByAPIKey(ctx context.Context, hash string) (string, error) {
+	ctx, span := s.start(ctx, "GetUserIDByAPIKey")
+	userID, err := s.s.GetUserIDByAPIKey(ctx, hash)
+	end(span, err)
+	return userID, err
+}
+
+// Close не принимает контекст, поэтому спана у него нет.
+func (s *tracedStorage) Close() error {
+	return s.s.Close()
+}
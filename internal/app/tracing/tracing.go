@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName — имя, под которым сервис создаёт свои спаны.
+const instrumentationName = "github.com/eduardtungatarov/shortener"
+
+const serviceName = "shortener"
+
+// tracer берётся у глобального провайдера при каждом вызове: пока Start не установил провайдер,
+// спаны ничего не стоят и никуда не уходят.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start включает разбор и передачу заголовка W3C traceparent и, если задан cfg.Endpoint,
+// отправку спанов в OTLP/HTTP-коллектор. Возвращаемая функция отправляет накопленные спаны
+// и останавливает экспорт; её нужно вызвать при остановке сервиса.
+func Start(ctx context.Context, cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Решение вызывающего сервиса из traceparent важнее нашей доли, иначе трасса рвётся.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
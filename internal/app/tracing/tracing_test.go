@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eduardtungatarov/shortener/internal/app/config"
+	"github.com/eduardtungatarov/shortener/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans подменяет глобальный провайдер на время теста и возвращает записанные им спаны.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	_, err := Start(context.Background(), config.Tracing{})
+	require.NoError(t, err)
+
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func spansByName(rec *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+	return spans
+}
+
+type userKey struct{}
+
+func TestRequestSpans(t *testing.T) {
+	rec := recordSpans(t)
+
+	withUser := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("X-User") == "" {
+				res.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), userKey{}, req.Header.Get("X-User"))))
+		})
+	}
+
+	r := chi.NewRouter()
+	r.Use(WithRequests)
+	r.Use(Middleware("withUser", withUser))
+	r.Get("/{shortUrl}", Handler("get", func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "user", req.Context().Value(userKey{}), "значения из middleware доходят до обработчика")
+		res.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("X-User", "user")
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	require.Equal(t, http.StatusTemporaryRedirect, res.Code)
+
+	spans := spansByName(rec)
+	require.Len(t, spans, 3)
+
+	server := spans["GET /{shortUrl}"]
+	require.NotNil(t, server, "серверный спан называется по шаблону маршрута")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String(), "трасса продолжается из traceparent")
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, codes.Unset, server.Status().Code)
+
+	// Middleware и обработчик — соседи внутри серверного спана.
+	for _, name := range []string{"middleware withUser", "handler get"} {
+		require.Contains(t, spans, name)
+		assert.Equal(t, server.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+	}
+	assert.False(t, spans["middleware withUser"].EndTime().After(spans["handler get"].StartTime()),
+		"спан middleware заканчивается до начала обработчика")
+
+	rec.Reset()
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+
+	spans = spansByName(rec)
+	assert.Len(t, spans, 2, "до обработчика запрос не дошёл")
+	assert.Contains(t, spans, "middleware withUser")
+}
+
+type failingStorage struct {
+	storage.Storage
+}
+
+func (s failingStorage) Get(ctx context.Context, key string) (string, error) {
+	if key == "broken" {
+		return "", errors.New("connection reset")
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func TestInstrumentStorage(t *testing.T) {
+	rec := recordSpans(t)
+	s := InstrumentStorage(failingStorage{storage.MakeMemoryStorage()}, storage.BackendMemory)
+
+	ctx := context.WithValue(context.Background(), config.UserIDKeyName, "user")
+	require.NoError(t, s.Set(ctx, "abc", "https://ya.ru/", time.Time{}))
+	_, err := s.Get(ctx, "unknown")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.Get(ctx, "broken")
+	require.Error(t, err)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 3)
+	for _, span := range spans {
+		assert.Contains(t, span.Attributes(), backendKey.String(storage.BackendMemory))
+	}
+	assert.Equal(t, "storage.Set", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[1].Status().Code, "ErrNotFound — ответ о данных, а не сбой")
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+}
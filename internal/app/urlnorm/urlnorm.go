@@ -0,0 +1,104 @@
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	ErrInvalid          = errors.New("url is invalid")
+	ErrSchemeNotAllowed = errors.New("url scheme is not allowed")
+	ErrHostMissing      = errors.New("url has no host")
+)
+
+// DefaultSchemes — схемы, которые можно сокращать, если другие не заданы.
+var DefaultSchemes = []string{"http", "https"}
+
+// defaultPorts не пишутся в нормальной форме url.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normalizer проверяет url и приводит его к нормальной форме, чтобы равнозначные url
+// получали один ключ: схема и хост в нижнем регистре, IDN-хост в punycode,
+// без порта по умолчанию. Пустой путь заменяется на "/", остальные пути не меняются:
+// "/a" и "/a/" могут вести на разные страницы.
+type Normalizer struct {
+	schemes map[string]bool
+}
+
+func MakeNormalizer(schemes ...string) *Normalizer {
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+
+	n := &Normalizer{schemes: make(map[string]bool, len(schemes))}
+	for _, s := range schemes {
+		n.schemes[strings.ToLower(s)] = true
+	}
+	return n
+}
+
+func (n *Normalizer) Normalize(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !n.schemes[scheme] {
+		return "", fmt.Errorf("%w: %q", ErrSchemeNotAllowed, u.Scheme)
+	}
+
+	if u.Opaque != "" || u.Hostname() == "" {
+		return "", ErrHostMissing
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port == defaultPorts[scheme] {
+		port = ""
+	}
+
+	u.Scheme = scheme
+	u.Host = joinHostPort(host, port)
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+
+	return u.String(), nil
+}
+
+// normalizeHost переводит доменное имя в punycode в нижнем регистре, IP-адреса оставляет как есть.
+func normalizeHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: host %q: %v", ErrInvalid, host, err)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+func joinHostPort(host, port string) string {
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
@@ -0,0 +1,57 @@
+package urlnorm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr error
+	}{
+		{name: "unchanged", raw: "https://practicum.yandex.ru/", want: "https://practicum.yandex.ru/"},
+		{name: "empty_path", raw: "https://ya.ru", want: "https://ya.ru/"},
+		{name: "path_trailing_slash_kept", raw: "https://ya.ru/a/", want: "https://ya.ru/a/"},
+		{name: "case", raw: "HTTPS://YA.Ru/Path", want: "https://ya.ru/Path"},
+		{name: "default_port", raw: "http://ya.ru:80/a?b=c", want: "http://ya.ru/a?b=c"},
+		{name: "custom_port", raw: "https://ya.ru:8443", want: "https://ya.ru:8443/"},
+		{name: "idn", raw: "https://пример.рф/путь", want: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "ipv6", raw: "http://[::1]:80", want: "http://[::1]/"},
+		{name: "spaces", raw: "  https://ya.ru/  ", want: "https://ya.ru/"},
+		{name: "javascript", raw: "javascript:alert(1)", wantErr: ErrSchemeNotAllowed},
+		{name: "no_scheme", raw: "ya.ru", wantErr: ErrSchemeNotAllowed},
+		{name: "no_host", raw: "https:///path", wantErr: ErrHostMissing},
+		{name: "opaque", raw: "http:ya.ru", wantErr: ErrHostMissing},
+		{name: "garbage", raw: "https://ya ru/", wantErr: ErrInvalid},
+		{name: "bad_host", raw: "https://-ya.ru/", wantErr: ErrInvalid},
+	}
+
+	n := MakeNormalizer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := n.Normalize(tt.raw)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNormalizeSchemes(t *testing.T) {
+	n := MakeNormalizer("https")
+
+	_, err := n.Normalize("http://ya.ru/")
+	assert.ErrorIs(t, err, ErrSchemeNotAllowed)
+
+	got, err := n.Normalize("https://ya.ru/")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru/", got)
+}